@@ -0,0 +1,450 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	cdn "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/cdn/v20180606"
+	"gopkg.in/yaml.v3"
+
+	"git.ghink.net/ghink/PurgeCOSPathCache/purge"
+)
+
+// TencentCloudProfile holds the credentials and API settings of one Tencent Cloud account
+type TencentCloudProfile struct {
+	// Name identifies the profile in reports, required when several profiles are configured
+	Name      string `yaml:"name" json:"name" toml:"name"`
+	SecretID  string `yaml:"secret_id" json:"secret_id" toml:"secret_id"`
+	SecretKey string `yaml:"secret_key" json:"secret_key" toml:"secret_key"`
+	// Region is optional, CDN is a global service and purges apply to every node of the area
+	Region string `yaml:"region" json:"region" toml:"region"`
+	// SignRegion overrides the region requests are signed for, for region-scoped endpoints
+	SignRegion string `yaml:"sign_region" json:"sign_region" toml:"sign_region"`
+	// Partition is the Tencent Cloud site of the account, cn, intl or finance, selecting the default endpoint
+	Partition string `yaml:"partition" json:"partition" toml:"partition"`
+	// Language selects the language of API error messages, en-US or zh-CN
+	Language string `yaml:"language" json:"language" toml:"language"`
+	// Token is the optional session token of STS temporary credentials
+	Token string `yaml:"token" json:"token" toml:"token"`
+	// CredentialsFile names a YAML or JSON file holding secret_id, secret_key and token,
+	// which take precedence over the inline values
+	CredentialsFile string `yaml:"credentials_file" json:"credentials_file" toml:"credentials_file"`
+	// SecretDir names a directory holding secret_id, secret_key and optionally token as
+	// separate files, like a Kubernetes secret volume, taking precedence over CredentialsFile
+	SecretDir string `yaml:"secret_dir" json:"secret_dir" toml:"secret_dir"`
+	// MaxRetries and RetryBaseDelay (in seconds) control retries of transient API errors
+	MaxRetries     *int `yaml:"max_retries" json:"max_retries" toml:"max_retries"`
+	RetryBaseDelay int  `yaml:"retry_base_delay" json:"retry_base_delay" toml:"retry_base_delay"`
+	// RetryableCodes adds SDK error codes to the retried ones, or with RetryableCodesMode
+	// replace stands in for the defaults
+	RetryableCodes     []string `yaml:"retryable_codes" json:"retryable_codes" toml:"retryable_codes"`
+	RetryableCodesMode string   `yaml:"retryable_codes_mode" json:"retryable_codes_mode" toml:"retryable_codes_mode"`
+	// TimeoutSeconds bounds every HTTP request made to the API
+	TimeoutSeconds int `yaml:"timeout_seconds" json:"timeout_seconds" toml:"timeout_seconds"`
+	// Endpoint and Scheme override the API host and protocol, e.g. to target a local stub
+	Endpoint string `yaml:"endpoint" json:"endpoint" toml:"endpoint"`
+	Scheme   string `yaml:"scheme" json:"scheme" toml:"scheme"`
+	// RequestsPerSecond limits the rate of API calls made with this account
+	RequestsPerSecond float64 `yaml:"requests_per_second" json:"requests_per_second" toml:"requests_per_second"`
+	// Proxy routes API calls through this proxy URL, overriding HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+	Proxy string `yaml:"proxy" json:"proxy" toml:"proxy"`
+	// HTTP tunes the connections to the API
+	HTTP httpSettings `yaml:"http" json:"http" toml:"http"`
+}
+
+// httpSettings is the http section of a profile. Durations are in seconds and zero values
+// select the defaults of the purge package.
+type httpSettings struct {
+	MaxIdleConns        int `yaml:"max_idle_conns" json:"max_idle_conns" toml:"max_idle_conns"`
+	MaxIdleConnsPerHost int `yaml:"max_idle_conns_per_host" json:"max_idle_conns_per_host" toml:"max_idle_conns_per_host"`
+	IdleConnTimeout     int `yaml:"idle_conn_timeout" json:"idle_conn_timeout" toml:"idle_conn_timeout"`
+	// KeepAlive is the TCP keep-alive probe interval, negative disables probes
+	KeepAlive         int  `yaml:"keep_alive" json:"keep_alive" toml:"keep_alive"`
+	DisableKeepAlives bool `yaml:"disable_keep_alives" json:"disable_keep_alives" toml:"disable_keep_alives"`
+	// HTTP2 allows HTTP/2 when the endpoint offers it, unset means true
+	HTTP2 *bool `yaml:"http2" json:"http2" toml:"http2"`
+}
+
+// transportOptions converts the http section of a profile
+func (h httpSettings) transportOptions() purge.TransportOptions {
+	return purge.TransportOptions{
+		MaxIdleConns:        h.MaxIdleConns,
+		MaxIdleConnsPerHost: h.MaxIdleConnsPerHost,
+		IdleConnTimeout:     time.Duration(h.IdleConnTimeout) * time.Second,
+		KeepAlive:           time.Duration(h.KeepAlive) * time.Second,
+		DisableKeepAlives:   h.DisableKeepAlives,
+		DisableHTTP2:        h.HTTP2 != nil && !*h.HTTP2,
+	}
+}
+
+// TencentCloudProfiles is the tencent_cloud section, which is either a single
+// profile mapping or a list of named profiles
+type TencentCloudProfiles []TencentCloudProfile
+
+// UnmarshalYAML accepts both the single mapping and the list form of tencent_cloud
+func (p *TencentCloudProfiles) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.MappingNode {
+		var single TencentCloudProfile
+		if err := node.Decode(&single); err != nil {
+			return err
+		}
+		*p = TencentCloudProfiles{single}
+		return nil
+	}
+
+	var list []TencentCloudProfile
+	if err := node.Decode(&list); err != nil {
+		return err
+	}
+	*p = list
+	return nil
+}
+
+// UnmarshalJSON accepts both the single object and the array form of tencent_cloud
+func (p *TencentCloudProfiles) UnmarshalJSON(data []byte) error {
+	var single TencentCloudProfile
+	if err := json.Unmarshal(data, &single); err == nil {
+		*p = TencentCloudProfiles{single}
+		return nil
+	}
+
+	var list []TencentCloudProfile
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*p = list
+	return nil
+}
+
+// UnmarshalTOML accepts both the single table and the array of tables form of tencent_cloud
+func (p *TencentCloudProfiles) UnmarshalTOML(value interface{}) error {
+	if _, ok := value.(map[string]interface{}); ok {
+		var single TencentCloudProfile
+		if err := decodeTOMLValue(value, &single); err != nil {
+			return err
+		}
+		*p = TencentCloudProfiles{single}
+		return nil
+	}
+
+	var list []TencentCloudProfile
+	if err := decodeTOMLValue(value, &list); err != nil {
+		return err
+	}
+	*p = list
+	return nil
+}
+
+// defaultProfileName labels the profile of a single-account configuration
+const defaultProfileName = "default"
+
+// defaultTimeoutSeconds is the HTTP request timeout used when timeout_seconds is unset
+const defaultTimeoutSeconds = 10
+
+// Supported values of retryable_codes_mode
+const (
+	retryableCodesAppend  = "append"
+	retryableCodesReplace = "replace"
+)
+
+// retryableCodes returns the SDK error codes retried for a profile, appending its
+// retryable_codes to the defaults unless retryable_codes_mode is replace
+func retryableCodes(cloud *TencentCloudProfile) []string {
+	if cloud.RetryableCodesMode == retryableCodesReplace {
+		return append([]string{}, cloud.RetryableCodes...)
+	}
+	return append(slices.Clone(purge.DefaultRetryableCodes), cloud.RetryableCodes...)
+}
+
+// applyProfileDefaults fills in the optional profile settings left unset
+func applyProfileDefaults(cloud *TencentCloudProfile) {
+	if cloud.MaxRetries == nil {
+		maxRetries := purge.DefaultMaxRetries
+		cloud.MaxRetries = &maxRetries
+	}
+	if cloud.RetryBaseDelay == 0 {
+		cloud.RetryBaseDelay = int(purge.DefaultRetryBaseDelay / time.Second)
+	}
+	if cloud.TimeoutSeconds == 0 {
+		cloud.TimeoutSeconds = defaultTimeoutSeconds
+	}
+	if cloud.RequestsPerSecond == 0 {
+		cloud.RequestsPerSecond = purge.DefaultRequestsPerSecond
+	}
+}
+
+// profileCredentials is the content of a credentials_file
+type profileCredentials struct {
+	SecretID  string `yaml:"secret_id" json:"secret_id" toml:"secret_id"`
+	SecretKey string `yaml:"secret_key" json:"secret_key" toml:"secret_key"`
+	Token     string `yaml:"token" json:"token" toml:"token"`
+}
+
+// apply replaces the credentials of cloud with the values set in c
+func (c profileCredentials) apply(cloud *TencentCloudProfile) {
+	if c.SecretID != "" {
+		cloud.SecretID = c.SecretID
+	}
+	if c.SecretKey != "" {
+		cloud.SecretKey = c.SecretKey
+	}
+	if c.Token != "" {
+		cloud.Token = c.Token
+	}
+}
+
+// loadCredentialsFiles reads the credentials_file and secret_dir of every profile that sets
+// them, so secrets can be kept out of a committed config. Values set in the file replace the
+// inline ones, and those of the directory replace both.
+func loadCredentialsFiles(profiles TencentCloudProfiles) error {
+	for i := range profiles {
+		cloud := &profiles[i]
+		if cloud.CredentialsFile != "" {
+			data, err := os.ReadFile(cloud.CredentialsFile)
+			if err != nil {
+				return fmt.Errorf("failed to read credentials file: %v", err)
+			}
+
+			var credentials profileCredentials
+			if strings.EqualFold(filepath.Ext(cloud.CredentialsFile), ".json") {
+				err = json.Unmarshal(data, &credentials)
+			} else {
+				// YAML also accepts JSON
+				err = unmarshalYAML(data, &credentials)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to parse credentials file %s: %v", cloud.CredentialsFile, err)
+			}
+			credentials.apply(cloud)
+		}
+		if cloud.SecretDir != "" {
+			credentials, err := readSecretDir(cloud.SecretDir)
+			if err != nil {
+				return err
+			}
+			credentials.apply(cloud)
+		}
+	}
+	return nil
+}
+
+// readSecretDir reads the credentials stored one per file in dir, as a Kubernetes secret
+// volume mounts them. The trailing newline such files often end with is trimmed.
+func readSecretDir(dir string) (profileCredentials, error) {
+	read := func(name string, required bool) (string, error) {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if errors.Is(err, os.ErrNotExist) && !required {
+			return "", nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s from secret directory: %v", name, err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+
+	var credentials profileCredentials
+	var err error
+	if credentials.SecretID, err = read("secret_id", true); err != nil {
+		return credentials, err
+	}
+	if credentials.SecretKey, err = read("secret_key", true); err != nil {
+		return credentials, err
+	}
+	credentials.Token, err = read("token", false)
+	return credentials, err
+}
+
+// Environment variables that supply API credentials
+const (
+	envSecretID  = "TENCENTCLOUD_SECRET_ID"
+	envSecretKey = "TENCENTCLOUD_SECRET_KEY"
+	envToken     = "TENCENTCLOUD_SESSION_TOKEN"
+)
+
+// resolveCredentials overrides the configured credentials with environment variables when set,
+// so a shared base config can be specialized per environment. Environment credentials only
+// apply to single-account configurations, since they cannot identify a profile.
+func resolveCredentials(config *Config) {
+	if len(config.TencentCloud) == 0 {
+		config.TencentCloud = TencentCloudProfiles{{Name: defaultProfileName}}
+		applyProfileDefaults(&config.TencentCloud[0])
+	}
+	if len(config.TencentCloud) > 1 {
+		return
+	}
+
+	cloud := &config.TencentCloud[0]
+	if secretID := os.Getenv(envSecretID); secretID != "" {
+		cloud.SecretID = secretID
+	}
+	if secretKey := os.Getenv(envSecretKey); secretKey != "" {
+		cloud.SecretKey = secretKey
+	}
+	if token := os.Getenv(envToken); token != "" {
+		cloud.Token = token
+	}
+}
+
+// validateProfiles checks that every profile carries credentials and valid settings
+func validateProfiles(profiles TencentCloudProfiles) error {
+	problems := []error{validateProfileSettings(profiles)}
+	for _, cloud := range profiles {
+		if cloud.SecretID == "" {
+			problems = append(problems, fmt.Errorf("secret_id is required for profile %q: set %s, tencent_cloud.secret_dir, tencent_cloud.credentials_file or tencent_cloud.secret_id (in that order of precedence)", cloud.Name, envSecretID))
+		}
+		if cloud.SecretKey == "" {
+			problems = append(problems, fmt.Errorf("secret_key is required for profile %q: set %s, tencent_cloud.secret_dir, tencent_cloud.credentials_file or tencent_cloud.secret_key (in that order of precedence)", cloud.Name, envSecretKey))
+		}
+	}
+	return errors.Join(problems...)
+}
+
+// validateProfileSettings checks the profile names and API settings, leaving out credentials
+func validateProfileSettings(profiles TencentCloudProfiles) error {
+	var problems []error
+	names := make(map[string]bool)
+	for _, cloud := range profiles {
+		if len(profiles) > 1 {
+			switch {
+			case cloud.Name == "":
+				problems = append(problems, errors.New("name is required for every tencent_cloud profile when several are configured"))
+			case names[cloud.Name]:
+				problems = append(problems, newValueError(cloud.Name, "duplicate tencent_cloud profile name %q", cloud.Name))
+			}
+			names[cloud.Name] = true
+		}
+
+		if *cloud.MaxRetries < 0 || cloud.RetryBaseDelay < 0 {
+			problems = append(problems, fmt.Errorf("max_retries and retry_base_delay must not be negative for profile %q", cloud.Name))
+		}
+		if cloud.TimeoutSeconds < 0 {
+			problems = append(problems, fmt.Errorf("timeout_seconds must not be negative for profile %q", cloud.Name))
+		}
+		if mode := cloud.RetryableCodesMode; mode != "" && mode != retryableCodesAppend && mode != retryableCodesReplace {
+			problems = append(problems, newValueError(cloud.RetryableCodesMode, "unsupported retryable_codes_mode %q for profile %q, expected %s or %s",
+				cloud.RetryableCodesMode, cloud.Name, retryableCodesAppend, retryableCodesReplace))
+		}
+		if cloud.HTTP.MaxIdleConns < 0 || cloud.HTTP.MaxIdleConnsPerHost < 0 || cloud.HTTP.IdleConnTimeout < 0 {
+			problems = append(problems, fmt.Errorf("http max_idle_conns, max_idle_conns_per_host and idle_conn_timeout must not be negative for profile %q", cloud.Name))
+		}
+		if cloud.RequestsPerSecond < 0 {
+			problems = append(problems, fmt.Errorf("requests_per_second must not be negative for profile %q", cloud.Name))
+		}
+		if cloud.Scheme != "" && !skipEnumValidation && !strings.EqualFold(cloud.Scheme, "http") && !strings.EqualFold(cloud.Scheme, "https") {
+			problems = append(problems, newValueError(cloud.Scheme, "unsupported scheme %q for profile %q, expected http or https", cloud.Scheme, cloud.Name))
+		}
+		if !skipEnumValidation && !isKnownRegion(cloud.Region) {
+			problems = append(problems, unknownRegionError("region", cloud.Region, cloud.Name))
+		}
+		if !skipEnumValidation && !isKnownRegion(cloud.SignRegion) {
+			problems = append(problems, unknownRegionError("sign_region", cloud.SignRegion, cloud.Name))
+		}
+		if _, err := purge.PartitionEndpoint(cloud.Partition, cloud.Region); err != nil {
+			problems = append(problems, newValueError(cloud.Partition, "%v for profile %q", err, cloud.Name))
+		} else if cloud.Partition != purge.PartitionFinance && purge.IsFinanceRegion(cloud.Region) {
+			problems = append(problems, newValueError(cloud.Region, "region %q belongs to the finance cloud, set partition: finance for profile %q", cloud.Region, cloud.Name))
+		}
+		if cloud.Language != "" && cloud.Language != purge.LanguageEnglish && cloud.Language != purge.LanguageChinese {
+			problems = append(problems, newValueError(cloud.Language, "unsupported language %q for profile %q, expected %s or %s",
+				cloud.Language, cloud.Name, purge.LanguageEnglish, purge.LanguageChinese))
+		}
+		if cloud.Proxy != "" {
+			if parsed, err := url.Parse(cloud.Proxy); err != nil || parsed.Scheme == "" || parsed.Host == "" {
+				problems = append(problems, newValueError(cloud.Proxy, "invalid proxy %q for profile %q, expected a URL such as http://proxy:3128", redactedURL(cloud.Proxy), cloud.Name))
+			}
+		}
+	}
+	return errors.Join(problems...)
+}
+
+// unknownRegionError reports a region setting of a profile missing from knownRegions,
+// suggesting the closest known region
+func unknownRegionError(field, region, profileName string) error {
+	message := fmt.Sprintf("unknown %s %q for profile %q", field, region, profileName)
+	if suggestion := suggestRegion(region); suggestion != "" {
+		message += fmt.Sprintf(", did you mean %q?", suggestion)
+	} else {
+		message += ", CDN is a global service so it can be left empty"
+	}
+	return newValueError(region, "%s", message)
+}
+
+// account pairs a credential profile with the purge client built from it
+type account struct {
+	profile *TencentCloudProfile
+	purger  *purge.Client
+	// client is the SDK client of purger, for the API calls the purge package does not wrap
+	client *cdn.Client
+}
+
+// call runs one API call under the account's rate limit, retrying transient errors,
+// within a span named after the API action
+func (a *account) call(ctx context.Context, action string, fn func() error) error {
+	_, span := startCallSpan(ctx, a, action)
+	err := a.purger.Call(ctx, fn)
+	endCallSpan(span, err)
+	return err
+}
+
+// newPurgeOptions maps a profile onto the settings of its purge client
+func newPurgeOptions(cloud *TencentCloudProfile) purge.Options {
+	return purge.Options{
+		// Credentials come from the environment or configuration file rather than being hardcoded
+		SecretID:   cloud.SecretID,
+		SecretKey:  cloud.SecretKey,
+		Token:      cloud.Token,
+		Region:     cloud.Region,
+		SignRegion: cloud.SignRegion,
+		Partition:  cloud.Partition,
+		Language:   cloud.Language,
+		Endpoint:   cloud.Endpoint,
+		Scheme:     cloud.Scheme,
+		Proxy:      cloud.Proxy,
+		// Identify the tool in the API access logs
+		UserAgent: userAgent(),
+		HTTP:      cloud.HTTP.transportOptions(),
+		// The timeout applies to every call made with this client, including quota checks and task polling
+		Timeout:           time.Duration(cloud.TimeoutSeconds) * time.Second,
+		MaxRetries:        *cloud.MaxRetries,
+		RetryBaseDelay:    time.Duration(cloud.RetryBaseDelay) * time.Second,
+		RetryableCodes:    retryableCodes(cloud),
+		RequestsPerSecond: cloud.RequestsPerSecond,
+		Logger:            logger.With("profile", cloud.Name),
+	}
+}
+
+// profileEndpoint returns the API host a profile calls, the configured endpoint or the
+// default host of its partition
+func profileEndpoint(cloud *TencentCloudProfile) string {
+	if cloud.Endpoint != "" {
+		return cloud.Endpoint
+	}
+	// NewClient has already rejected a partition without an endpoint
+	endpoint, _ := purge.PartitionEndpoint(cloud.Partition, cloud.Region)
+	return endpoint
+}
+
+// newAccount creates the purge client for a profile
+func newAccount(cloud *TencentCloudProfile) (*account, error) {
+	purger, err := purge.NewClient(newPurgeOptions(cloud))
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("created CDN client",
+		"profile", cloud.Name,
+		"partition", cloud.Partition,
+		"endpoint", profileEndpoint(cloud),
+		"scheme", cloud.Scheme,
+		"region", cloud.Region,
+		"sign_region", cloud.SignRegion,
+		"proxy", redactedURL(cloud.Proxy))
+	return &account{profile: cloud, purger: purger, client: purger.CDN()}, nil
+}
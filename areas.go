@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// areaList is purge_config.area, either a single area or a list of areas each purged
+// with a request of its own
+type areaList []string
+
+// UnmarshalYAML accepts both the single string and the list form of area
+func (a *areaList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*a = parseAreaList(node.Value)
+		return nil
+	}
+
+	var list []string
+	if err := node.Decode(&list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// UnmarshalJSON accepts both the single string and the array form of area
+func (a *areaList) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = parseAreaList(single)
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// UnmarshalTOML accepts both the single string and the array form of area
+func (a *areaList) UnmarshalTOML(value interface{}) error {
+	if single, ok := value.(string); ok {
+		*a = parseAreaList(single)
+		return nil
+	}
+
+	var list []string
+	if err := decodeTOMLValue(value, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// String joins the areas with commas, the form accepted by -area and PURGECOS_AREA
+func (a areaList) String() string {
+	return strings.Join(a, ",")
+}
+
+// parseAreaList splits a comma-separated area setting, an empty one selecting no area
+func parseAreaList(value string) areaList {
+	if value == "" {
+		return nil
+	}
+	areas := strings.Split(value, ",")
+	for i := range areas {
+		areas[i] = strings.TrimSpace(areas[i])
+	}
+	return areas
+}
+
+// targets returns the areas purged by a run, in order. Without a configured area a
+// single purge is submitted without one, which purges mainland nodes only.
+func (a areaList) targets() []string {
+	if len(a) == 0 {
+		return []string{""}
+	}
+	return a
+}
+
+// validateAreas checks every element of purge_config.area
+func validateAreas(areas areaList) error {
+	var problems []error
+	seen := map[string]bool{}
+	for _, area := range areas {
+		if area == "" {
+			problems = append(problems, errors.New("purge_config.area lists an empty area"))
+			continue
+		}
+		if seen[area] {
+			problems = append(problems, newValueError(area, "purge_config.area lists %q twice", area))
+			continue
+		}
+		seen[area] = true
+		problems = append(problems, validateArea("purge_config.area", area))
+	}
+	return errors.Join(problems...)
+}
+
+// warnRedundantAreas warns when global is listed with other areas, which it already covers,
+// so the other purges only spend quota
+func warnRedundantAreas(areas areaList) {
+	if len(areas) > 1 && slices.Contains(areas, areaGlobal) {
+		logger.Warn(fmt.Sprintf("purge_config.area lists %s, which already covers every node, so purging the other areas is redundant", areaGlobal),
+			"areas", areas.String())
+	}
+}
+
+// areaConfig returns a copy of config submitting its purges to a single area
+func areaConfig(config *Config, area string) *Config {
+	copied := *config
+	copied.PurgeConfig.Area = area
+	return &copied
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	cdn "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/cdn/v20180606"
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+
+	"git.ghink.net/ghink/PurgeCOSPathCache/purge"
+)
+
+// Results of a single check of the check command
+const (
+	checkPass = "pass"
+	checkFail = "fail"
+	checkSkip = "skip"
+)
+
+// checkTaskWindow is the time range of the DescribePurgeTasks call made by the check command
+const checkTaskWindow = time.Hour
+
+// checkFlags are the flags of the check command
+type checkFlags struct {
+	*flag.FlagSet
+	domains *bool
+}
+
+// newCheckFlags creates the flag set of the check command
+func newCheckFlags() *checkFlags {
+	fs := newFlagSet("check", "", "Verify endpoint connectivity, credentials and quota access of every profile with read-only API calls.")
+	return &checkFlags{
+		FlagSet: fs,
+		domains: fs.Bool("domains", false, "Also verify that the host of every purge path is an accelerated domain of each profile"),
+	}
+}
+
+// checkCommand verifies that every profile can reach its endpoint, authenticate and read
+// its purge quota, using only read-only API calls
+func checkCommand(ctx context.Context, source *configSource, args []string) {
+	flags := newCheckFlags()
+	flags.Parse(args)
+
+	config := loadCommandConfig(ctx, source)
+	if err := validateProfiles(config.TencentCloud); err != nil {
+		out.Exitf(exitConfig, "Configuration validation failed: %v", err)
+	}
+	var domainPaths []string
+	if *flags.domains {
+		if len(config.PurgeConfig.Paths) == 0 {
+			out.Exitf(exitConfig, "-domains requires purge paths to check")
+		}
+		domainPaths = config.PurgeConfig.Paths
+	}
+
+	summary := checkSummary{Accounts: []accountCheck{}}
+	failedProfiles, exitCode := forEachAccount(ctx, config, func(acct *account) error {
+		result, err := checkAccount(ctx, acct, domainPaths)
+		summary.Accounts = append(summary.Accounts, result)
+		return err
+	})
+
+	if len(failedProfiles) > 0 {
+		summary.Error = fmt.Sprintf("Checks failed for %d of %d profiles: %s",
+			len(failedProfiles), len(config.TencentCloud), strings.Join(failedProfiles, ", "))
+		out.Fail(exitCode, summary.Error, summary)
+	}
+	out.Successf("All checks passed\n")
+	out.Result(summary)
+}
+
+// checkAccount runs the checks of one profile, printing a line per check, and verifies
+// the hosts of domainPaths when set. It returns the error of the first failed check; the
+// checks depending on it are skipped.
+func checkAccount(ctx context.Context, acct *account, domainPaths []string) (accountCheck, error) {
+	endpoint := profileEndpoint(acct.profile)
+	result := accountCheck{Name: acct.profile.Name, Endpoint: endpoint}
+	report := func(name, status, detail string) {
+		result.Checks = append(result.Checks, checkResult{Name: name, Status: status, Detail: detail})
+		switch status {
+		case checkPass:
+			out.Successf("  %-14s %s  %s\n", name, status, detail)
+		case checkFail:
+			out.Errorf("  %-14s %s  %s\n", name, status, detail)
+		default:
+			out.Printf("  %-14s %s  %s\n", name, status, detail)
+		}
+	}
+
+	// Any answer from the API, even a rejection, proves the endpoint is reachable
+	err := describeRecentPurgeTask(ctx, acct)
+	if err != nil && exitCodeFor(err) == exitNetwork {
+		report("connectivity", checkFail, purge.DescribeError(err))
+		report("authentication", checkSkip, "endpoint unreachable")
+		report("quota access", checkSkip, "endpoint unreachable")
+		return result, fmt.Errorf("endpoint %s unreachable: %w", endpoint, err)
+	}
+	report("connectivity", checkPass, endpoint)
+
+	if err != nil {
+		if exitCodeFor(err) == exitAuth {
+			report("authentication", checkFail, purge.DescribeError(err))
+			report("quota access", checkSkip, "credentials rejected")
+			return result, fmt.Errorf("credentials rejected: %s: %w", purge.DescribeError(err), err)
+		}
+		// Authenticated but not allowed to list tasks, which the quota check reports on its own
+		report("authentication", checkPass, "credentials accepted, DescribePurgeTasks: "+purge.DescribeError(err))
+	} else {
+		report("authentication", checkPass, "credentials accepted")
+	}
+
+	quota, err := describePurgeQuota(ctx, acct)
+	if err != nil {
+		report("quota access", checkFail, purge.DescribeError(err))
+		return result, fmt.Errorf("quota query failed: %s: %w", purge.DescribeError(err), err)
+	}
+	report("quota access", checkPass, fmt.Sprintf("%d path and %d url quota entries", len(quota.PathPurge), len(quota.UrlPurge)))
+
+	if domainPaths != nil {
+		if err := checkPathDomains(ctx, acct, domainPaths); err != nil {
+			report("domains", checkFail, err.Error())
+			return result, err
+		}
+		report("domains", checkPass, "every path host is an accelerated domain")
+	}
+	return result, nil
+}
+
+// domainPageSize is the number of domains listed per DescribeDomains call, the API maximum
+const domainPageSize = 1000
+
+// describeAccountDomains lists the accelerated domains of an account, wildcard domains
+// such as *.example.com included
+func describeAccountDomains(ctx context.Context, acct *account) ([]string, error) {
+	var domains []string
+	for offset := int64(0); ; offset += domainPageSize {
+		request := cdn.NewDescribeDomainsRequest()
+		request.Offset = common.Int64Ptr(offset)
+		request.Limit = common.Int64Ptr(domainPageSize)
+		var response *cdn.DescribeDomainsResponse
+		err := acct.call(ctx, "DescribeDomains", func() (err error) {
+			response, err = acct.client.DescribeDomainsWithContext(ctx, request)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		// A response without its body ends the listing, like an empty page
+		if response.Response == nil {
+			return domains, nil
+		}
+		for _, domain := range response.Response.Domains {
+			if domain != nil && domain.Domain != nil {
+				domains = append(domains, *domain.Domain)
+			}
+		}
+		if len(response.Response.Domains) < domainPageSize || response.Response.TotalNumber == nil || int64(len(domains)) >= *response.Response.TotalNumber {
+			return domains, nil
+		}
+	}
+}
+
+// checkPathDomains verifies that the host of every path is an accelerated domain of the
+// account, failing with the hosts that are not, which usually means the wrong account
+func checkPathDomains(ctx context.Context, acct *account, paths []string) error {
+	domains, err := describeAccountDomains(ctx, acct)
+	if err != nil {
+		return fmt.Errorf("failed to list the domains of the account: %s: %w", purge.DescribeError(err), err)
+	}
+	var unknown []string
+	for _, path := range paths {
+		parsed, err := url.Parse(path)
+		if err != nil {
+			continue
+		}
+		if host := parsed.Hostname(); !domainAllowed(host, domains) && !slices.Contains(unknown, host) {
+			unknown = append(unknown, host)
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("%w of profile %q: %s", errUnknownDomains, acct.profile.Name, strings.Join(unknown, ", "))
+	}
+	logger.Info("verified path domains", "profile", acct.profile.Name, "domains", len(domains))
+	return nil
+}
+
+// describeRecentPurgeTask lists at most one purge task of the last hour, the cheapest
+// authenticated call of the CDN API
+func describeRecentPurgeTask(ctx context.Context, acct *account) error {
+	now := time.Now().In(apiTimeZone)
+	request := cdn.NewDescribePurgeTasksRequest()
+	request.StartTime = common.StringPtr(now.Add(-checkTaskWindow).Format(taskTimeLayout))
+	request.EndTime = common.StringPtr(now.Format(taskTimeLayout))
+	request.Limit = common.Int64Ptr(1)
+	return acct.call(ctx, "DescribePurgeTasks", func() error {
+		_, err := acct.client.DescribePurgeTasksWithContext(ctx, request)
+		return err
+	})
+}
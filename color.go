@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Supported values for the --color flag
+const (
+	colorAuto   = "auto"
+	colorAlways = "always"
+	colorNever  = "never"
+)
+
+// envNoColor disables colors in auto mode when set to any non-empty value, see https://no-color.org
+const envNoColor = "NO_COLOR"
+
+// ANSI escape sequences used to highlight output lines
+const (
+	ansiReset  = "\x1b[0m"
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+)
+
+// useColor decides whether to colorize the output written to f in the given mode.
+// Auto mode only colors terminals, and never when NO_COLOR is set.
+func useColor(mode string, f *os.File) (bool, error) {
+	switch mode {
+	case colorAlways:
+		return true, nil
+	case colorNever:
+		return false, nil
+	case colorAuto:
+		return os.Getenv(envNoColor) == "" && isTerminal(f), nil
+	default:
+		return false, fmt.Errorf("unsupported color mode %q, expected %s, %s or %s", mode, colorAuto, colorAlways, colorNever)
+	}
+}
+
+// paint wraps text in the given ANSI color when enabled, keeping any trailing newline
+// outside the color so the reset is never left dangling on the next line
+func paint(enabled bool, color, text string) string {
+	if !enabled || text == "" {
+		return text
+	}
+	body, newline := text, ""
+	if text[len(text)-1] == '\n' {
+		body, newline = text[:len(text)-1], "\n"
+	}
+	return color + body + ansiReset + newline
+}
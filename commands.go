@@ -0,0 +1,760 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"git.ghink.net/ghink/PurgeCOSPathCache/purge"
+)
+
+// command is a subcommand dispatched on the first non-flag argument
+type command struct {
+	name    string
+	summary string
+	// flags creates the flag set run parses, whose flags the completion scripts offer
+	flags func() *flag.FlagSet
+	run   func(ctx context.Context, source *configSource, args []string)
+}
+
+// commands lists the subcommands in the order shown by --help
+var commands = []command{
+	{name: "purge", summary: "Purge the configured paths from the CDN cache",
+		flags: func() *flag.FlagSet { return newPurgeFlags().FlagSet }, run: purgeCommand},
+	{name: "watch", summary: "Purge the URLs of files as they change under changed_files.root",
+		flags: func() *flag.FlagSet { return newWatchFlags().FlagSet }, run: watchCommand},
+	{name: "push", summary: "Prefetch push_config.urls into the CDN cache",
+		flags: func() *flag.FlagSet { return newPushFlags().FlagSet }, run: pushCommand},
+	{name: "status", summary: "Show the status of purge tasks",
+		flags: func() *flag.FlagSet { return newStatusFlags().FlagSet }, run: statusCommand},
+	{name: "quota", summary: "Show the remaining purge quota",
+		flags: newQuotaFlags, run: quotaCommand},
+	{name: "check", summary: "Verify connectivity, credentials and quota access",
+		flags: func() *flag.FlagSet { return newCheckFlags().FlagSet }, run: checkCommand},
+	{name: "render", summary: "Print the API requests the configuration resolves to, offline",
+		flags: func() *flag.FlagSet { return newRenderFlags().FlagSet }, run: renderCommand},
+	{name: "validate", summary: "Check the configuration without calling the API",
+		flags: newValidateFlags, run: validateCommand},
+	{name: "list", summary: "Print the accepted regions, areas or flush types",
+		flags: newListFlags, run: listCommand},
+}
+
+// findCommand returns the subcommand with the given name, or nil if there is none
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+// newFlagSet creates the flag set of a subcommand, whose usage shows the given arguments
+func newFlagSet(name, arguments, description string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		usage := strings.TrimSpace(fmt.Sprintf("%s [global flags] %s [flags] %s", os.Args[0], name, arguments))
+		fmt.Fprintf(fs.Output(), "Usage: %s\n\n%s\n", usage, description)
+		hasFlags := false
+		fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+		if hasFlags {
+			fmt.Fprintf(fs.Output(), "\nFlags:\n")
+			fs.PrintDefaults()
+		}
+	}
+	return fs
+}
+
+// loadCommandConfig loads the configuration files and applies environment credentials,
+// exiting on failure
+func loadCommandConfig(ctx context.Context, source *configSource) *Config {
+	config, err := loadConfig(ctx, source)
+	if err != nil {
+		out.Exitf(exitConfig, "Error loading configuration: %v", err)
+	}
+	logger.Debug("loaded configuration", "paths", source.files.String())
+	redactPaths = redactPaths || config.PurgeConfig.RedactPaths
+
+	// Apply credentials supplied through the environment
+	resolveCredentials(config)
+
+	for _, cloud := range config.TencentCloud {
+		logger.Debug("resolved credentials", "profile", cloud.Name, "secret_id", cloud.SecretID, "has_token", cloud.Token != "")
+	}
+	return config
+}
+
+// Environment variables overriding purge_config settings, themselves overridden by the
+// flags of the purge command
+const (
+	envFlushType = "PURGECOS_FLUSH_TYPE"
+	envArea      = "PURGECOS_AREA"
+	envURLEncode = "PURGECOS_URL_ENCODE"
+)
+
+// applyPurgeOverrides applies the environment variables, then the flags given on the command
+// line, over the purge_config settings of the files. The result is validated like the files.
+func applyPurgeOverrides(config *Config, fs *flag.FlagSet) error {
+	if flushType := os.Getenv(envFlushType); flushType != "" {
+		config.PurgeConfig.FlushType = flushType
+	}
+	if area := os.Getenv(envArea); area != "" {
+		config.PurgeConfig.Areas = parseAreaList(area)
+	}
+	if urlEncode := os.Getenv(envURLEncode); urlEncode != "" {
+		if err := config.PurgeConfig.UrlEncode.Set(urlEncode); err != nil {
+			return fmt.Errorf("invalid %s: %v", envURLEncode, err)
+		}
+	}
+
+	// Only flags actually given override, so -url-encode=false can switch encoding off
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "flush-type":
+			config.PurgeConfig.FlushType = f.Value.String()
+		case "area":
+			config.PurgeConfig.Areas = parseAreaList(f.Value.String())
+		case "url-encode":
+			config.PurgeConfig.UrlEncode = *f.Value.(*urlEncodeSetting)
+		case "max-paths":
+			config.PurgeConfig.MaxPaths = f.Value.(flag.Getter).Get().(int)
+		}
+	})
+	return nil
+}
+
+// forEachAccount runs fn with every configured profile, continuing past failures.
+// It returns the names of the failed profiles and the exit code of the first failure.
+func forEachAccount(ctx context.Context, config *Config, fn func(acct *account) error) ([]string, int) {
+	var failedProfiles []string
+	exitCode := exitOK
+	for i := range config.TencentCloud {
+		// Skip the remaining profiles once interrupted or past the deadline
+		if ctx.Err() != nil {
+			break
+		}
+		cloud := &config.TencentCloud[i]
+		if len(config.TencentCloud) > 1 {
+			out.Printf("Profile %s:\n", cloud.Name)
+		}
+
+		acct, err := newAccount(cloud)
+		if err != nil {
+			err = fmt.Errorf("error creating CDN client: %w", err)
+		} else {
+			err = fn(acct)
+		}
+		if err != nil {
+			failedProfiles = append(failedProfiles, cloud.Name)
+			if exitCode == exitOK {
+				exitCode = exitCodeFor(err)
+			}
+			out.Errorf("Profile %s failed: %v\n", cloud.Name, err)
+			printFailedRequestIDs(purge.RequestIDs(err))
+		}
+	}
+	return failedProfiles, exitCode
+}
+
+// printFailedRequestIDs prints the request ids of rejected API calls on a line of their own
+func printFailedRequestIDs(ids []string) {
+	if len(ids) > 0 {
+		out.Errorf("Failed request ids: %s\n", strings.Join(ids, ", "))
+	}
+}
+
+// purgeFlags are the flags of the purge command
+type purgeFlags struct {
+	*flag.FlagSet
+	push                *bool
+	wait                *bool
+	checkQuota          *bool
+	checkDomains        *bool
+	dryRun              *bool
+	explain             *bool
+	outputFile          *string
+	metricsFile         *string
+	idempotencyKey      *string
+	stateFilePath       *string
+	diff                *bool
+	commaPaths          *string
+	summaryOnly         *bool
+	verbose             *bool
+	failFast            *bool
+	autoScheme          *bool
+	since               *time.Duration
+	sinceFile           *string
+	failureManifestPath *string
+	replayPath          *string
+	retryOnPartial      *bool
+	paths               configPaths
+	assumeYes           bool
+}
+
+// newPurgeFlags creates the flag set of the purge command
+func newPurgeFlags() *purgeFlags {
+	fs := newFlagSet("purge", "[-]", "Purge the configured paths. Pass - to also purge the paths read from stdin.")
+	f := &purgeFlags{FlagSet: fs}
+	f.push = fs.Bool("push", false, "Prefetch push_config.urls after a successful purge")
+	f.wait = fs.Bool("wait", false, "Wait until the submitted purge tasks have completed")
+	f.checkQuota = fs.Bool("check-quota", false, "Check remaining purge quota before submitting")
+	f.checkDomains = fs.Bool("check-domains", false, "Verify with DescribeDomains that every path host is an accelerated domain of each profile before submitting")
+	f.dryRun = fs.Bool("dry-run", false, "Print the requests that would be sent without calling the API")
+	f.explain = fs.Bool("explain", false, "Describe in plain words what the purge will do and its estimated quota cost before submitting, combine with -dry-run to submit nothing")
+	f.outputFile = fs.String("output-file", "", "Append the run result as a JSON line to this file")
+	f.metricsFile = fs.String("metrics-file", "", "Write Prometheus textfile collector metrics about the run to this file")
+	f.idempotencyKey = fs.String("idempotency-key", "", "Skip the purge if a run with this key succeeded within 24 hours, as recorded in -state-file; auto derives the key from the paths and settings")
+	f.stateFilePath = fs.String("state-file", "", "Skip paths purged within purge_config.dedupe_window, as recorded in this file, and record the purged ones")
+	f.diff = fs.Bool("diff", false, "Print the paths added and removed since the last run recorded in -state-file, without submitting anything")
+	fs.String("flush-type", "", "Override purge_config.flush_type and "+envFlushType)
+	fs.String("area", "", "Override purge_config.area and "+envArea+", comma-separated to purge several areas")
+	fs.Var(new(urlEncodeSetting), "url-encode", "Override purge_config.url_encode and "+envURLEncode+": true, false or auto")
+	fs.Int("max-paths", 0, "Override purge_config.max_paths, the number of paths above which the run is aborted")
+	fs.Var(&f.paths, "path", "Purge this path instead of the configured ones, repeat for several")
+	f.commaPaths = fs.String("paths", "", "Comma-separated paths to purge instead of the configured ones")
+	f.summaryOnly = fs.Bool("summary-only", false, "Only print the totals of the run, leaving out the per-profile output")
+	f.verbose = fs.Bool("verbose", false, "Print the outcome of every batch")
+	f.failFast = fs.Bool("fail-fast", false, "Stop submitting batches and profiles after the first rejected batch instead of attempting all of them")
+	f.autoScheme = fs.Bool("auto-scheme", false, "Prepend https:// to paths without a protocol header instead of failing")
+	f.since = fs.Duration("since", 0, "Purge only the files under purge_config.changed_files.root modified this long ago or less (e.g. 30m), plus the listed deletions")
+	f.sinceFile = fs.String("since-file", "", "Like -since, selecting the files modified after this marker file")
+	f.failureManifestPath = fs.String("failure-manifest", "", "Write the failed batches of each profile to this file for -replay, removing it when every batch succeeds")
+	f.replayPath = fs.String("replay", "", "Resubmit only the failed batches recorded in this failure manifest, instead of the configured paths")
+	f.retryOnPartial = fs.Bool("retry-on-partial", false, "Resubmit the failed batches once before giving up")
+	fs.BoolVar(&f.assumeYes, "yes", false, "Skip the confirmation prompt for large purges")
+	fs.BoolVar(&f.assumeYes, "y", false, "Shorthand for -yes")
+	return f
+}
+
+// purgeCommand purges the configured paths with every profile
+func purgeCommand(ctx context.Context, source *configSource, args []string) {
+	flags := newPurgeFlags()
+	flags.Parse(args)
+
+	if *flags.commaPaths != "" {
+		flags.paths = append(flags.paths, strings.Split(*flags.commaPaths, ",")...)
+	}
+	if len(flags.paths) > 0 && flags.Arg(0) == "-" {
+		out.Exitf(exitConfig, "Paths can be given with -path/-paths or on stdin, not both")
+	}
+	changedOnly := *flags.since > 0 || *flags.sinceFile != ""
+	if changedOnly && (len(flags.paths) > 0 || flags.Arg(0) == "-") {
+		out.Exitf(exitConfig, "-since and -since-file select the paths themselves and cannot be combined with -path, -paths or stdin")
+	}
+	if *flags.replayPath != "" && (changedOnly || len(flags.paths) > 0 || flags.Arg(0) == "-") {
+		out.Exitf(exitConfig, "-replay resubmits the paths of the manifest and cannot be combined with -since, -path, -paths or stdin")
+	}
+	if *flags.idempotencyKey != "" && *flags.stateFilePath == "" {
+		out.Exitf(exitConfig, "-idempotency-key requires -state-file to record the submitted keys")
+	}
+	if *flags.diff && *flags.stateFilePath == "" {
+		out.Exitf(exitConfig, "-diff requires -state-file to compare against the last recorded run")
+	}
+	// Ad-hoc paths need no configuration file, credentials and flush type then come from the
+	// environment and flags
+	source.skipDefault = len(flags.paths) > 0
+
+	// A lone "-" argument merges the paths piped on stdin with the configured ones
+	if flags.Arg(0) == "-" {
+		var err error
+		if source.stdinPaths, err = parsePathList(os.Stdin); err != nil {
+			out.Exitf(exitConfig, "Error reading paths from stdin: %v", err)
+		}
+	}
+
+	config := loadCommandConfig(ctx, source)
+	if err := applyPurgeOverrides(config, flags.FlagSet); err != nil {
+		out.Exitf(exitConfig, "Configuration validation failed: %v", err)
+	}
+
+	// Paths given on the command line replace the configured ones
+	if len(flags.paths) > 0 {
+		config.PurgeConfig.Paths = flags.paths
+		config.PurgeConfig.PathEntries, config.PurgeConfig.FlushTypes = nil, nil
+	}
+
+	// Incremental deploys purge the files they changed instead of the configured paths
+	if changedOnly {
+		cutoff, err := changedSinceCutoff(*flags.since, *flags.sinceFile, time.Now())
+		if err != nil {
+			out.Exitf(exitConfig, "%v", err)
+		}
+		if config.PurgeConfig.Paths, err = changedFilePaths(config, cutoff); err != nil {
+			out.Exitf(exitConfig, "Error selecting changed files: %v", err)
+		}
+		config.PurgeConfig.PathEntries, config.PurgeConfig.FlushTypes = nil, nil
+		if len(config.PurgeConfig.Paths) == 0 {
+			out.Printf("No files changed under %s since %s\n", config.PurgeConfig.ChangedFiles.Root, cutoff.Format(time.RFC3339))
+			out.Result(emptyRunSummary())
+			return
+		}
+	}
+
+	// A replay resubmits the failed batches of a previous run, with that run's settings
+	var replay map[string]map[string][]string
+	if *flags.replayPath != "" {
+		manifest, err := readFailureManifest(*flags.replayPath)
+		if err != nil {
+			out.Exitf(exitConfig, "%v", err)
+		}
+		if replay, err = applyReplay(config, manifest); err != nil {
+			out.Exitf(exitConfig, "%v", err)
+		}
+		logger.Info("replaying failed batches", "manifest", *flags.replayPath, "batches", len(manifest.Batches), "paths", len(config.PurgeConfig.Paths))
+	}
+
+	normalizeConfigPaths(config, *flags.autoScheme)
+	warnPathModes(config.PurgeConfig.Paths, config.PurgeConfig.PurgeMode)
+	warnMainlandOnly(config)
+	warnRedundantAreas(config.PurgeConfig.Areas)
+
+	// Validate required configuration fields
+	if err := validateConfig(config); err != nil {
+		out.Exitf(exitConfig, "Configuration validation failed: %v", err)
+	}
+	if *flags.push {
+		if err := validatePushConfig(config); err != nil {
+			out.Exitf(exitConfig, "Configuration validation failed: %v", err)
+		}
+	}
+
+	// Skip paths a recent run already purged, typically when a deploy is retried
+	window := time.Duration(config.PurgeConfig.DedupeWindow) * time.Second
+	runPaths := config.PurgeConfig.Paths
+	var state *stateFile
+	if *flags.stateFilePath != "" {
+		var err error
+		if state, err = openStateFile(*flags.stateFilePath); err != nil {
+			out.Exitf(exitFailure, "Error opening state file: %v", err)
+		}
+		defer state.Close()
+
+		// Show the blast radius of a configuration change and stop before any API call
+		if *flags.diff {
+			printPathDiff(state, runPaths)
+			return
+		}
+
+		// A repeated run with the same key reprints the recorded result instead of purging again
+		if *flags.idempotencyKey == idempotencyKeyAuto {
+			*flags.idempotencyKey = deriveIdempotencyKey(config)
+		}
+		if prior, ok := state.submitted(*flags.idempotencyKey, time.Now()); ok {
+			out.Printf("Purge with idempotency key %s already submitted at %s, not purging again\n",
+				*flags.idempotencyKey, prior.SubmittedAt.Format(time.RFC3339))
+			prior.Result.printTotals()
+			out.Result(prior.Result)
+			return
+		}
+
+		var skipped int
+		config.PurgeConfig.Paths, skipped = state.skipRecent(config.PurgeConfig.Paths, window, time.Now())
+		logger.Info("skipped recently purged paths", "skipped", skipped, "dedupe_window", window)
+	}
+
+	// Split paths into batches the API accepts in a single call
+	batches := purge.Batches(newPurgeConfig(config))
+	logger.Info("resolved configuration",
+		"paths", len(config.PurgeConfig.Paths),
+		"batches", len(batches),
+		"purge_mode", config.PurgeConfig.PurgeMode,
+		"profiles", len(config.TencentCloud))
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.Int("purge.path_count", len(config.PurgeConfig.Paths)),
+		attribute.Int("purge.batch_count", len(batches)),
+		attribute.String("purge.mode", config.PurgeConfig.PurgeMode),
+		attribute.String("purge.flush_type", config.PurgeConfig.FlushType),
+		attribute.String("purge.area", config.PurgeConfig.Areas.String()))
+
+	// Guard against a runaway path list burning the whole quota
+	if len(config.PurgeConfig.Paths) > config.PurgeConfig.MaxPaths {
+		out.Exitf(exitConfig, "Refusing to purge %d paths, more than max_paths %d: raise purge_config.max_paths or pass -max-paths if this is intentional",
+			len(config.PurgeConfig.Paths), config.PurgeConfig.MaxPaths)
+	}
+
+	if *flags.explain {
+		out.Printf("%s\n", explainPurge(config))
+	}
+
+	// Catch typos in the URLs before spending quota, a dry run prechecks them too
+	if config.PurgeConfig.PrecheckURLs {
+		if err := runPrecheck(ctx, config); err != nil {
+			out.Exitf(exitCodeFor(err), "Purge aborted: %v", err)
+		}
+	}
+
+	// Show exactly what would be submitted and stop before any API call
+	if *flags.dryRun {
+		var summary dryRunSummary
+		areas := config.PurgeConfig.Areas.targets()
+		for _, area := range areas {
+			if len(areas) > 1 {
+				out.Printf("Area %s:\n", area)
+			}
+			purgeConfig := newPurgeConfig(areaConfig(config, area))
+			for i, batch := range batches {
+				request := renderBatchRequest(purgeConfig, batch)
+				summary.Requests = append(summary.Requests, json.RawMessage(request))
+				out.Printf("Batch %d/%d request: %s\n", i+1, len(batches), request)
+			}
+		}
+		if *flags.push {
+			summary.PushRequest = json.RawMessage(newPushRequest(config).ToJsonString())
+			out.Printf("Push request: %s\n", summary.PushRequest)
+		}
+		out.Result(summary)
+		return
+	}
+
+	if len(batches) == 0 {
+		out.Printf("No paths to purge\n")
+		out.Result(emptyRunSummary())
+		return
+	}
+
+	// Guard against accidentally purging a large number of paths
+	threshold := config.PurgeConfig.ConfirmThreshold
+	if threshold > 0 && len(config.PurgeConfig.Paths) > threshold && !flags.assumeYes {
+		if err := confirmPurge(config, os.Stdin, os.Stderr); err != nil {
+			out.Exitf(exitFailure, "%v", err)
+		}
+	}
+
+	if config.PurgeConfig.PreHook != "" {
+		if err := runPreHook(ctx, config); err != nil {
+			out.Exitf(exitFailure, "Purge aborted: %v", err)
+		}
+	}
+
+	// Purge with every profile even if an earlier one fails, then report them all
+	summary := runSummary{
+		Timestamp:  time.Now().UTC().Format(time.RFC3339),
+		TaskIDs:    []string{},
+		RequestIDs: []string{},
+		PathCount:  len(config.PurgeConfig.Paths),
+		FlushType:  config.PurgeConfig.FlushType,
+		Area:       config.PurgeConfig.Areas.String(),
+		PurgeMode:  config.PurgeConfig.PurgeMode,
+	}
+	opts := runOptions{
+		push:         *flags.push,
+		wait:         *flags.wait,
+		checkQuota:   *flags.checkQuota || config.PurgeConfig.CheckQuota,
+		checkDomains: *flags.checkDomains || config.PurgeConfig.CheckDomains,
+		// The per-batch detail is the opposite of a summary, so -verbose wins
+		summaryOnly:    *flags.summaryOnly && !*flags.verbose,
+		verbose:        *flags.verbose,
+		failFast:       *flags.failFast,
+		retryOnPartial: *flags.retryOnPartial,
+	}
+	var failedProfiles []string
+	exitCode := exitOK
+	// The run is timed from the first API call, waiting and pushing included
+	started := time.Now()
+	for i := range config.TencentCloud {
+		// Skip the remaining profiles once interrupted or past the deadline
+		if ctx.Err() != nil {
+			break
+		}
+		if opts.failFast && len(failedProfiles) > 0 {
+			logger.Warn("skipping the remaining profiles after a failure", "profiles", len(config.TencentCloud)-i)
+			break
+		}
+		cloud := &config.TencentCloud[i]
+		profileOpts := opts
+		if replay != nil {
+			// Profiles only replay their own failed batches
+			profileOpts.replay = replay[cloud.Name]
+			if len(profileOpts.replay) == 0 {
+				logger.Info("no failed batches to replay", "profile", cloud.Name)
+				continue
+			}
+		}
+		if len(config.TencentCloud) > 1 && !opts.summaryOnly {
+			out.Printf("Profile %s:\n", cloud.Name)
+		}
+
+		result, err := purgeWithProfile(ctx, cloud, config, profileOpts)
+		if err != nil {
+			result.Error = err.Error()
+			result.FailedRequestIDs = purge.RequestIDs(err)
+			failedProfiles = append(failedProfiles, cloud.Name)
+			if exitCode == exitOK {
+				exitCode = exitCodeFor(err)
+			}
+			out.Errorf("Profile %s failed: %v\n", cloud.Name, err)
+			printFailedRequestIDs(result.FailedRequestIDs)
+		}
+		summary.add(result)
+	}
+	summary.DurationMS = time.Since(started).Milliseconds()
+
+	// The manifest only helps a rerun, so failing to write it does not fail the run
+	if *flags.failureManifestPath != "" {
+		manifest := newFailureManifest(config, summary.Accounts, time.Now())
+		if err := writeFailureManifest(*flags.failureManifestPath, manifest); err != nil {
+			logger.Error("failed to write failure manifest", "path", *flags.failureManifestPath, "error", err)
+		} else if len(manifest.Batches) > 0 {
+			out.Printf("Failed batches written to %s, resubmit them with -replay %s\n", *flags.failureManifestPath, *flags.failureManifestPath)
+		}
+	}
+
+	// Only paths every profile purged are recorded, so a retry still covers the failed ones.
+	// The state only saves quota, so failing to write it does not fail the run.
+	if state != nil {
+		if *flags.idempotencyKey != "" && ctx.Err() == nil && len(failedProfiles) == 0 {
+			state.rememberKey(*flags.idempotencyKey, summary, time.Now())
+		}
+		// A replay only resubmits part of a run, so it is not compared against
+		if replay == nil {
+			state.rememberRun(runPaths, time.Now())
+		}
+		summary.MonthlyQuotaUsed = state.addQuotaUsage(config.PurgeConfig.PurgeMode, summary.QuotaUsed, time.Now())
+		if err := state.record(purgedByAll(summary.Accounts, len(config.TencentCloud)), window, time.Now()); err != nil {
+			logger.Error("failed to write state file", "path", *flags.stateFilePath, "error", err)
+		}
+	}
+
+	if ctx.Err() != nil {
+		// Report what was already submitted so nothing is purged twice when rerunning
+		submitted := "none"
+		if len(summary.TaskIDs) > 0 {
+			submitted = strings.Join(summary.TaskIDs, ", ")
+		}
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			summary.Error = fmt.Sprintf("Purge deadline exceeded, task ids already submitted: %s", submitted)
+			exitCode = exitNetwork
+		} else {
+			summary.Error = fmt.Sprintf("Purge interrupted, task ids already submitted: %s", submitted)
+			exitCode = exitInterrupted
+		}
+	} else if len(failedProfiles) > 0 {
+		summary.Error = fmt.Sprintf("Purge operation failed for %d of %d profiles: %s",
+			len(failedProfiles), len(config.TencentCloud), strings.Join(failedProfiles, ", "))
+	}
+
+	// Metrics only feed monitoring, so failing to write them does not fail the run
+	if *flags.metricsFile != "" {
+		if err := writeMetrics(*flags.metricsFile, summary.Error != "", summary.PathCount, time.Now()); err != nil {
+			logger.Error("failed to write metrics file", "path", *flags.metricsFile, "error", err)
+		}
+	}
+
+	// Webhook failures are logged but never change the exit code
+	if config.Notify.WebhookURL != "" && shouldNotify(config.Notify.On, summary.Error != "") {
+		if err := sendNotification(config.Notify.WebhookURL, &summary); err != nil {
+			logger.Error("webhook notification failed", "error", err)
+		}
+	}
+	// Like the webhook, the post-hook only reports the run
+	if config.PurgeConfig.PostHook != "" {
+		if err := runPostHook(ctx, config, &summary); err != nil {
+			logger.Error("post hook failed", "error", err)
+		}
+	}
+
+	// A missing audit record is treated as a failed run
+	if *flags.outputFile != "" {
+		if err := appendRecord(*flags.outputFile, summary); err != nil {
+			out.Exitf(exitFailure, "Error writing output file: %v", err)
+		}
+	}
+
+	if len(config.TencentCloud) > 1 || opts.summaryOnly {
+		summary.printTotals()
+	}
+	summary.printQuotaUsage()
+	if opts.summaryOnly {
+		summary.Accounts = nil
+	}
+	if summary.Error != "" {
+		out.Fail(exitCode, summary.Error, summary)
+	}
+	summary.printCompletion(len(config.PurgeConfig.Areas.targets()))
+
+	out.Result(summary)
+}
+
+// printPathDiff prints the paths added and removed since the last run recorded in state
+func printPathDiff(state *stateFile, paths []string) {
+	added, removed, unchanged := state.diffLastRun(paths)
+	summary := diffSummary{Added: displayPaths(added), Removed: displayPaths(removed), Unchanged: unchanged}
+	if state.state.LastRun != nil {
+		summary.PreviousRun = state.state.LastRun.RecordedAt.UTC().Format(time.RFC3339)
+		out.Printf("Compared with the run of %s: %d paths added, %d removed, %d unchanged\n",
+			summary.PreviousRun, len(added), len(removed), summary.Unchanged)
+	} else {
+		out.Printf("No previous run recorded in %s, all %d paths are new\n", state.path, len(added))
+	}
+	for _, path := range summary.Added {
+		out.Printf("+ %s\n", path)
+	}
+	for _, path := range summary.Removed {
+		out.Printf("- %s\n", path)
+	}
+	if summary.Added == nil {
+		summary.Added = []string{}
+	}
+	if summary.Removed == nil {
+		summary.Removed = []string{}
+	}
+	out.Result(summary)
+}
+
+// purgedByAll returns the paths purged by every one of the profiles, in order
+func purgedByAll(accounts []accountSummary, profiles int) []string {
+	lists := make([][]string, len(accounts))
+	for i, account := range accounts {
+		lists[i] = account.purgedPaths
+	}
+	return commonPaths(lists, profiles)
+}
+
+// commonPaths returns the paths found in each of want lists, in order. Fewer lists than
+// want means a purge never ran, so no path is common to all.
+func commonPaths(lists [][]string, want int) []string {
+	if len(lists) < want {
+		return nil
+	}
+	counts := make(map[string]int)
+	var paths []string
+	for _, list := range lists {
+		seen := make(map[string]bool)
+		for _, path := range list {
+			if seen[path] {
+				continue
+			}
+			seen[path] = true
+			if counts[path]++; counts[path] == want {
+				paths = append(paths, path)
+			}
+		}
+	}
+	return paths
+}
+
+// purgeWithProfile creates the client for a profile and runs the purge with it
+func purgeWithProfile(ctx context.Context, cloud *TencentCloudProfile, config *Config, opts runOptions) (*accountSummary, error) {
+	acct, err := newAccount(cloud)
+	if err != nil {
+		return newAccountSummary(cloud), fmt.Errorf("error creating CDN client: %w", err)
+	}
+	return runAccount(ctx, acct, config, opts)
+}
+
+// pushFlags are the flags of the push command
+type pushFlags struct {
+	*flag.FlagSet
+	dryRun *bool
+}
+
+// newPushFlags creates the flag set of the push command
+func newPushFlags() *pushFlags {
+	fs := newFlagSet("push", "", "Prefetch push_config.urls into the CDN cache.")
+	return &pushFlags{
+		FlagSet: fs,
+		dryRun:  fs.Bool("dry-run", false, "Print the request that would be sent without calling the API"),
+	}
+}
+
+// pushCommand prefetches the configured URLs with every profile
+func pushCommand(ctx context.Context, source *configSource, args []string) {
+	flags := newPushFlags()
+	flags.Parse(args)
+
+	config := loadCommandConfig(ctx, source)
+	if err := validateProfiles(config.TencentCloud); err != nil {
+		out.Exitf(exitConfig, "Configuration validation failed: %v", err)
+	}
+	if err := validatePushConfig(config); err != nil {
+		out.Exitf(exitConfig, "Configuration validation failed: %v", err)
+	}
+
+	if *flags.dryRun {
+		request := json.RawMessage(newPushRequest(config).ToJsonString())
+		out.Printf("Push request: %s\n", request)
+		out.Result(dryRunSummary{Requests: []json.RawMessage{}, PushRequest: request})
+		return
+	}
+
+	summary := runSummary{
+		Timestamp:  time.Now().UTC().Format(time.RFC3339),
+		TaskIDs:    []string{},
+		RequestIDs: []string{},
+		PathCount:  len(config.PushConfig.Urls),
+		Area:       config.PushConfig.Area,
+	}
+	failedProfiles, exitCode := forEachAccount(ctx, config, func(acct *account) error {
+		result := newAccountSummary(acct.profile)
+		defer func() {
+			summary.Accounts = append(summary.Accounts, *result)
+			summary.AccountCount++
+		}()
+
+		response, err := pushUrlsCache(ctx, acct, config)
+		if err != nil {
+			err = fmt.Errorf("push failed: %s: %w", purge.DescribeError(err), err)
+			result.Error = err.Error()
+			result.FailedRequestIDs = purge.RequestIDs(err)
+			summary.FailedRequestIDs = append(summary.FailedRequestIDs, result.FailedRequestIDs...)
+			return err
+		}
+		result.PushTaskID = stringValue(response.TaskId)
+		summary.TaskIDs = append(summary.TaskIDs, result.PushTaskID)
+		out.Successf("Push operation completed successfully, task id: %s\n", result.PushTaskID)
+		if requestID := stringValue(response.RequestId); requestID != "" {
+			result.RequestIDs = append(result.RequestIDs, requestID)
+			summary.RequestIDs = append(summary.RequestIDs, requestID)
+			out.Printf("Push request id: %s\n", requestID)
+		}
+		return nil
+	})
+
+	if len(failedProfiles) > 0 {
+		summary.Error = fmt.Sprintf("Push operation failed for %d of %d profiles: %s",
+			len(failedProfiles), len(config.TencentCloud), strings.Join(failedProfiles, ", "))
+		out.Fail(exitCode, summary.Error, summary)
+	}
+	out.Result(summary)
+}
+
+// newQuotaFlags creates the flag set of the quota command
+func newQuotaFlags() *flag.FlagSet {
+	return newFlagSet("quota", "", "Show the remaining purge quota.")
+}
+
+// quotaCommand prints the remaining purge quota of every profile
+func quotaCommand(ctx context.Context, source *configSource, args []string) {
+	newQuotaFlags().Parse(args)
+
+	config := loadCommandConfig(ctx, source)
+	if err := validateProfiles(config.TencentCloud); err != nil {
+		out.Exitf(exitConfig, "Configuration validation failed: %v", err)
+	}
+
+	summary := quotaSummary{Accounts: []accountQuota{}}
+	failedProfiles, exitCode := forEachAccount(ctx, config, func(acct *account) error {
+		quota, err := describePurgeQuota(ctx, acct)
+		if err != nil {
+			return fmt.Errorf("quota query failed: %s: %w", purge.DescribeError(err), err)
+		}
+		printPurgeQuota(quota)
+		summary.Accounts = append(summary.Accounts, newAccountQuota(acct.profile.Name, quota))
+		return nil
+	})
+
+	if len(failedProfiles) > 0 {
+		summary.Error = fmt.Sprintf("Quota query failed for %d of %d profiles: %s",
+			len(failedProfiles), len(config.TencentCloud), strings.Join(failedProfiles, ", "))
+		out.Fail(exitCode, summary.Error, summary)
+	}
+	out.Result(summary)
+}
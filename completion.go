@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// completionShells are the shells the completion command generates scripts for
+var completionShells = []string{"bash", "zsh", "fish"}
+
+// The completion command lists the commands itself, so it is registered once they are
+// initialized
+func init() {
+	commands = append(commands, command{name: "completion", summary: "Print a shell completion script for bash, zsh or fish",
+		flags: newCompletionFlags, run: completionCommand})
+}
+
+// completionSpec is what the completion scripts offer after a command word
+type completionSpec struct {
+	name    string
+	summary string
+	flags   []*flag.Flag
+	// args are the values accepted as positional arguments, if they are enumerated
+	args []string
+}
+
+// completionSpecs describes every command from the flag set it parses
+func completionSpecs() []completionSpec {
+	specs := make([]completionSpec, 0, len(commands))
+	for _, cmd := range commands {
+		spec := completionSpec{name: cmd.name, summary: cmd.summary}
+		cmd.flags().VisitAll(func(f *flag.Flag) { spec.flags = append(spec.flags, f) })
+		switch cmd.name {
+		case "list":
+			spec.args = listingNames()
+		case "completion":
+			spec.args = completionShells
+		}
+		specs = append(specs, spec)
+	}
+	return specs
+}
+
+// newCompletionFlags creates the flag set of the completion command
+func newCompletionFlags() *flag.FlagSet {
+	return newFlagSet("completion", "<"+strings.Join(completionShells, "|")+">", "Print a completion script for the commands and flags of this tool.\n"+
+		"For bash: source <(PurgeCOSPathCache completion bash)")
+}
+
+// completionCommand prints the completion script of a shell
+func completionCommand(ctx context.Context, source *configSource, args []string) {
+	fs := newCompletionFlags()
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(exitConfig)
+	}
+
+	program := filepath.Base(os.Args[0])
+	var global []*flag.Flag
+	flag.VisitAll(func(f *flag.Flag) { global = append(global, f) })
+	specs := completionSpecs()
+	switch fs.Arg(0) {
+	case "bash":
+		fmt.Print(bashCompletion(program, global, specs))
+	case "zsh":
+		fmt.Print(zshCompletion(program, global, specs))
+	case "fish":
+		fmt.Print(fishCompletion(program, global, specs))
+	default:
+		out.Exitf(exitConfig, "Unsupported shell %q, expected %s", fs.Arg(0), strings.Join(completionShells, ", "))
+	}
+}
+
+// isBoolFlag reports whether f takes no value, as flag.FlagSet decides it
+func isBoolFlag(f *flag.Flag) bool {
+	boolFlag, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && boolFlag.IsBoolFlag()
+}
+
+// flagWords returns the flags as typed on the command line, sorted
+func flagWords(flags []*flag.Flag) []string {
+	words := make([]string, len(flags))
+	for i, f := range flags {
+		words[i] = "-" + f.Name
+	}
+	sort.Strings(words)
+	return words
+}
+
+// valueFlagPattern returns a shell case pattern matching the global flags that take a
+// value, whose value must be skipped when looking for the command word
+func valueFlagPattern(global []*flag.Flag) string {
+	var words []string
+	for _, f := range global {
+		if !isBoolFlag(f) {
+			words = append(words, "-"+f.Name)
+		}
+	}
+	sort.Strings(words)
+	return strings.Join(words, "|")
+}
+
+// shellFunction returns the name of the completion function of program
+func shellFunction(program string) string {
+	return "_" + strings.Map(func(r rune) rune {
+		if r == '-' || r == '.' {
+			return '_'
+		}
+		return r
+	}, program)
+}
+
+// words returns the words offered after the command word of spec
+func (spec completionSpec) words() string {
+	return strings.Join(append(flagWords(spec.flags), spec.args...), " ")
+}
+
+// bashCompletion generates the bash completion script
+func bashCompletion(program string, global []*flag.Flag, specs []completionSpec) string {
+	var b strings.Builder
+	function := shellFunction(program)
+	names := make([]string, len(specs))
+	for i, spec := range specs {
+		names[i] = spec.name
+	}
+	fmt.Fprintf(&b, "# bash completion for %s, generated by %s completion bash\n", program, program)
+	fmt.Fprintf(&b, "%s() {\n", function)
+	fmt.Fprintf(&b, "    local cur=\"${COMP_WORDS[COMP_CWORD]}\" cmd=\"\" i\n")
+	fmt.Fprintf(&b, "    for ((i = 1; i < COMP_CWORD; i++)); do\n")
+	fmt.Fprintf(&b, "        case \"${COMP_WORDS[i]}\" in\n")
+	fmt.Fprintf(&b, "            %s) ((i++)) ;;\n", valueFlagPattern(global))
+	fmt.Fprintf(&b, "            -*) ;;\n")
+	fmt.Fprintf(&b, "            *) cmd=\"${COMP_WORDS[i]}\"; break ;;\n")
+	fmt.Fprintf(&b, "        esac\n")
+	fmt.Fprintf(&b, "    done\n")
+	fmt.Fprintf(&b, "    local words\n")
+	fmt.Fprintf(&b, "    case \"$cmd\" in\n")
+	fmt.Fprintf(&b, "        \"\") words=\"%s\" ;;\n", strings.Join(append(flagWords(global), names...), " "))
+	for _, spec := range specs {
+		fmt.Fprintf(&b, "        %s) words=\"%s\" ;;\n", spec.name, spec.words())
+	}
+	fmt.Fprintf(&b, "    esac\n")
+	fmt.Fprintf(&b, "    COMPREPLY=($(compgen -W \"$words\" -- \"$cur\"))\n")
+	fmt.Fprintf(&b, "}\n")
+	fmt.Fprintf(&b, "complete -o default -F %s %s\n", function, program)
+	return b.String()
+}
+
+// zshCompletion generates the zsh completion script, with the commands described
+func zshCompletion(program string, global []*flag.Flag, specs []completionSpec) string {
+	var b strings.Builder
+	function := shellFunction(program)
+	fmt.Fprintf(&b, "#compdef %s\n", program)
+	fmt.Fprintf(&b, "# zsh completion for %s, generated by %s completion zsh\n", program, program)
+	fmt.Fprintf(&b, "%s() {\n", function)
+	fmt.Fprintf(&b, "    local cmd=\"\" i\n")
+	fmt.Fprintf(&b, "    for ((i = 2; i < CURRENT; i++)); do\n")
+	fmt.Fprintf(&b, "        case \"${words[i]}\" in\n")
+	fmt.Fprintf(&b, "            %s) ((i++)) ;;\n", valueFlagPattern(global))
+	fmt.Fprintf(&b, "            -*) ;;\n")
+	fmt.Fprintf(&b, "            *) cmd=\"${words[i]}\"; break ;;\n")
+	fmt.Fprintf(&b, "        esac\n")
+	fmt.Fprintf(&b, "    done\n")
+	fmt.Fprintf(&b, "    case \"$cmd\" in\n")
+	fmt.Fprintf(&b, "        \"\")\n")
+	fmt.Fprintf(&b, "            local -a commands=(\n")
+	for _, spec := range specs {
+		fmt.Fprintf(&b, "                %s\n", zshQuote(spec.name+":"+spec.summary))
+	}
+	fmt.Fprintf(&b, "            )\n")
+	fmt.Fprintf(&b, "            _describe command commands\n")
+	fmt.Fprintf(&b, "            compadd -- %s\n", strings.Join(flagWords(global), " "))
+	fmt.Fprintf(&b, "            ;;\n")
+	for _, spec := range specs {
+		fmt.Fprintf(&b, "        %s) compadd -- %s ;;\n", spec.name, spec.words())
+	}
+	fmt.Fprintf(&b, "    esac\n")
+	fmt.Fprintf(&b, "}\n")
+	fmt.Fprintf(&b, "compdef %s %s\n", function, program)
+	return b.String()
+}
+
+// fishCompletion generates the fish completion script, with every flag described
+func fishCompletion(program string, global []*flag.Flag, specs []completionSpec) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# fish completion for %s, generated by %s completion fish\n", program, program)
+	names := make([]string, len(specs))
+	for i, spec := range specs {
+		names[i] = spec.name
+	}
+	fmt.Fprintf(&b, "complete -c %s -f\n", program)
+	for _, f := range global {
+		fmt.Fprintf(&b, "complete -c %s -n __fish_use_subcommand %s\n", program, fishFlag(f))
+	}
+	for _, spec := range specs {
+		fmt.Fprintf(&b, "complete -c %s -n __fish_use_subcommand -a %s -d %s\n", program, spec.name, fishQuote(spec.summary))
+	}
+	for _, spec := range specs {
+		condition := fishQuote("__fish_seen_subcommand_from " + spec.name)
+		for _, f := range spec.flags {
+			fmt.Fprintf(&b, "complete -c %s -n %s %s\n", program, condition, fishFlag(f))
+		}
+		if len(spec.args) > 0 {
+			fmt.Fprintf(&b, "complete -c %s -n %s -a %s\n", program, condition, fishQuote(strings.Join(spec.args, " ")))
+		}
+	}
+	return b.String()
+}
+
+// fishFlag returns the fish options completing f, as an old-style single dash option
+// that requires a value unless it is a boolean
+func fishFlag(f *flag.Flag) string {
+	option := "-o " + f.Name
+	if !isBoolFlag(f) {
+		option += " -r"
+	}
+	return option + " -d " + fishQuote(firstLine(f.Usage))
+}
+
+// firstLine returns the first line of a flag usage
+func firstLine(usage string) string {
+	line, _, _ := strings.Cut(usage, "\n")
+	return line
+}
+
+// fishQuote quotes s as a single fish word
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+// zshQuote quotes s as a single zsh word
+func zshQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// isTerminal reports whether f is attached to an interactive terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// confirmPurge asks the operator to type yes before a large purge is submitted.
+// It refuses rather than blocking when stdin is not an interactive terminal.
+func confirmPurge(config *Config, in *os.File, prompt io.Writer) error {
+	if !isTerminal(in) {
+		return fmt.Errorf("refusing to purge %d paths without confirmation: stdin is not a terminal, pass -y to confirm non-interactively",
+			len(config.PurgeConfig.Paths))
+	}
+
+	area := config.PurgeConfig.Areas.String()
+	if area == "" {
+		area = "default"
+	}
+	fmt.Fprintf(prompt, "About to purge %d paths (purge mode: %s, flush type: %s, area: %s).\n",
+		len(config.PurgeConfig.Paths), config.PurgeConfig.PurgeMode, config.PurgeConfig.FlushType, area)
+	fmt.Fprint(prompt, "Type yes to continue: ")
+
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to read confirmation: %v", err)
+	}
+	if strings.TrimSpace(answer) != "yes" {
+		return errors.New("purge cancelled by user")
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	tencentCloudSDKErrors "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/errors"
+)
+
+// Process exit codes, one per failure category so wrapper scripts can react differently
+const (
+	exitOK      = 0
+	exitFailure = 1 // unclassified failure
+	exitConfig  = 2 // configuration loading or validation failed
+	exitAuth    = 3 // credentials were rejected
+	exitQuota   = 4 // purge quota exhausted
+	exitAPI     = 5 // the API returned another error
+	exitNetwork = 6 // network failure or timeout
+
+	exitInterrupted = 130 // stopped by SIGINT or SIGTERM, following the shell convention
+)
+
+// exitCodeHelp documents the exit codes in --help
+const exitCodeHelp = `Exit codes:
+  0  success
+  1  unclassified failure
+  2  configuration or validation error
+  3  authentication failure
+  4  purge quota exceeded
+  5  API error
+  6  network failure or timeout
+130  interrupted by SIGINT or SIGTERM
+`
+
+// Errors raised by the tool itself that map onto a dedicated exit code
+var (
+	errInsufficientQuota = errors.New("insufficient purge quota")
+	errWaitTimeout       = errors.New("timed out waiting for purge tasks")
+	// errUnknownDomains reports path hosts that are not domains of the account, which is
+	// usually the wrong account configured
+	errUnknownDomains = errors.New("hosts are not accelerated domains")
+	// errPrecheckFailed reports URLs a precheck_fail_on_error run found not served, which
+	// usually means a typo in the configured paths
+	errPrecheckFailed = errors.New("precheck found URLs that are not served")
+)
+
+// quotaErrorCodes are SDK error codes reporting an exhausted purge or push quota
+var quotaErrorCodes = []string{
+	"LimitExceeded.CdnPurgeExceedDayLimit",
+	"LimitExceeded.CdnPurgePathExceedDayLimit",
+	"LimitExceeded.CdnPurgeUrlExceedDayLimit",
+	"LimitExceeded.CdnPushExceedDayLimit",
+}
+
+// isQuotaError reports whether err is an SDK error rejecting a call for exhausted quota
+func isQuotaError(err error) bool {
+	var tencentCloudSDKError *tencentCloudSDKErrors.TencentCloudSDKError
+	if !errors.As(err, &tencentCloudSDKError) {
+		return false
+	}
+	for _, quotaCode := range quotaErrorCodes {
+		if tencentCloudSDKError.Code == quotaCode {
+			return true
+		}
+	}
+	return false
+}
+
+// exitCodeFor maps an error onto the exit code of its failure category
+func exitCodeFor(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	if errors.Is(err, context.Canceled) {
+		return exitInterrupted
+	}
+	if errors.Is(err, errInsufficientQuota) {
+		return exitQuota
+	}
+	if errors.Is(err, errUnknownDomains) || errors.Is(err, errPrecheckFailed) {
+		return exitConfig
+	}
+	if errors.Is(err, errWaitTimeout) || errors.Is(err, context.DeadlineExceeded) {
+		return exitNetwork
+	}
+
+	var tencentCloudSDKError *tencentCloudSDKErrors.TencentCloudSDKError
+	if errors.As(err, &tencentCloudSDKError) {
+		code := tencentCloudSDKError.Code
+		switch {
+		case strings.HasPrefix(code, "AuthFailure"), code == "ClientError.CredentialError":
+			return exitAuth
+		case code == "ClientError.NetworkError":
+			return exitNetwork
+		}
+		if isQuotaError(err) {
+			return exitQuota
+		}
+		return exitAPI
+	}
+
+	var netError net.Error
+	if errors.As(err, &netError) {
+		return exitNetwork
+	}
+	return exitFailure
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// flushTypeActions describe each flush type in plain words for -explain
+var flushTypeActions = map[string]string{
+	flushTypeFlush:  "mark stale",
+	flushTypeDelete: "evict",
+}
+
+// explainPurge describes in plain words what purging config will do, as printed by
+// -explain for operators unfamiliar with the CDN terminology
+func explainPurge(config *Config) string {
+	paths := config.PurgeConfig.Paths
+	kind, quota := "directory path", purgeQuotaName(config.PurgeConfig.PurgeMode)
+	if config.PurgeConfig.PurgeMode == purgeModeURL {
+		kind = "URL"
+	}
+
+	// URL purges take no flush type, directory purges group the paths by theirs
+	var actions []string
+	if config.PurgeConfig.PurgeMode == purgeModeURL {
+		actions = append(actions, "purge "+countOf(len(paths), kind))
+	} else {
+		counts := map[string]int{}
+		for _, path := range paths {
+			counts[pathFlushType(config, path)]++
+		}
+		for _, flushType := range flushTypes {
+			if counts[flushType.Value] > 0 {
+				actions = append(actions, fmt.Sprintf("%s (%s) %s",
+					flushTypeActions[flushType.Value], flushType.Value, countOf(counts[flushType.Value], kind)))
+			}
+		}
+	}
+	if len(actions) == 0 {
+		return "Will purge nothing: no paths are configured"
+	}
+
+	names := make([]string, len(config.TencentCloud))
+	for i, cloud := range config.TencentCloud {
+		names[i] = cloud.Name
+	}
+	accounts := "account " + joinAll(names)
+	if len(names) > 1 {
+		accounts = "accounts " + joinAll(names)
+	}
+
+	// Tencent Cloud counts every URL or directory once against the daily quota of the
+	// purge type, separately in each area and account
+	cost := fmt.Sprintf("estimated quota cost %d of the daily %s quota", len(paths), quota)
+	if len(config.PurgeConfig.Areas) > 1 && len(names) > 1 {
+		cost += " of each area and account"
+	} else if len(config.PurgeConfig.Areas) > 1 {
+		cost += " of each area"
+	} else if len(names) > 1 {
+		cost += " of each account"
+	}
+
+	return fmt.Sprintf("Will %s on %s for %s; %s", joinAll(actions), explainAreas(config.PurgeConfig.Areas), accounts, cost)
+}
+
+// pathFlushType returns the flush type a path is purged with, its own or the default
+func pathFlushType(config *Config, path string) string {
+	if flushType, ok := config.PurgeConfig.FlushTypes[path]; ok {
+		return flushType
+	}
+	return config.PurgeConfig.FlushType
+}
+
+// explainAreas names the nodes purged in the configured areas
+func explainAreas(areas areaList) string {
+	switch {
+	case len(areas) == 0:
+		return "mainland nodes (no area set)"
+	case len(areas) == 1 && areas[0] == areaGlobal:
+		return "all nodes"
+	}
+	return strings.Join(areas, " + ") + " nodes"
+}
+
+// countOf formats n with the singular or, adding an s, plural form of noun
+func countOf(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// joinAll joins names with commas and a final "and"
+func joinAll(names []string) string {
+	if len(names) < 2 {
+		return strings.Join(names, "")
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Names of the hooks, passed to the hook commands in PURGECOS_HOOK
+const (
+	hookPre  = "pre"
+	hookPost = "post"
+)
+
+// Environment variables set for hook commands on top of the tool's own environment
+const (
+	envHook      = "PURGECOS_HOOK"
+	envPathCount = "PURGECOS_PATH_COUNT"
+	envStatus    = "PURGECOS_STATUS"
+	envTaskIDs   = "PURGECOS_TASK_IDS"
+)
+
+// preHookInput is the JSON document piped to the pre_hook command
+type preHookInput struct {
+	Paths     []string `json:"paths"`
+	PurgeMode string   `json:"purge_mode"`
+	FlushType string   `json:"flush_type,omitempty"`
+	Area      string   `json:"area,omitempty"`
+	Profiles  []string `json:"profiles"`
+}
+
+// runPreHook runs purge_config.pre_hook with the resolved paths and settings on stdin.
+// A hook exiting non-zero aborts the purge.
+func runPreHook(ctx context.Context, config *Config) error {
+	input := preHookInput{
+		Paths:     config.PurgeConfig.Paths,
+		PurgeMode: config.PurgeConfig.PurgeMode,
+		FlushType: config.PurgeConfig.FlushType,
+		Area:      config.PurgeConfig.Areas.String(),
+	}
+	for _, cloud := range config.TencentCloud {
+		input.Profiles = append(input.Profiles, cloud.Name)
+	}
+	return runHook(ctx, hookPre, config.PurgeConfig.PreHook, input,
+		envPathCount+"="+strconv.Itoa(len(config.PurgeConfig.Paths)))
+}
+
+// runPostHook runs purge_config.post_hook with the run result on stdin, the same JSON as the
+// result printed in JSON output mode
+func runPostHook(ctx context.Context, config *Config, summary *runSummary) error {
+	status := notifySuccess
+	if summary.Error != "" {
+		status = notifyFailure
+	}
+	return runHook(ctx, hookPost, config.PurgeConfig.PostHook, summary,
+		envPathCount+"="+strconv.Itoa(summary.PathCount),
+		envStatus+"="+status,
+		envTaskIDs+"="+strings.Join(summary.TaskIDs, ","))
+}
+
+// runHook runs command through the system shell with input encoded as JSON on stdin and
+// logs every line it prints. The command is killed when ctx is done.
+func runHook(ctx context.Context, name, command string, input interface{}, env ...string) error {
+	data, err := json.Marshal(input)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s hook input: %v", name, err)
+	}
+
+	cmd := shellCommand(ctx, command)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Env = append(append(os.Environ(), envHook+"="+name), env...)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	logger.Info("running hook", "hook", name, "command", command)
+	err = cmd.Run()
+	for _, line := range strings.Split(strings.TrimRight(output.String(), "\n"), "\n") {
+		if line != "" {
+			logger.Info("hook output", "hook", name, "line", line)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%s_hook %q failed: %w", name, command, err)
+	}
+	return nil
+}
+
+// shellCommand runs command with sh, or cmd on Windows, so hooks can use pipes and arguments
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"git.ghink.net/ghink/PurgeCOSPathCache/purge"
+)
+
+// enumValue is one accepted value of a configuration setting, listed by the list command
+type enumValue struct {
+	Value       string `json:"value"`
+	Description string `json:"description"`
+}
+
+// purgeAreas are the accepted values of purge_config.area and push_config.area
+var purgeAreas = []enumValue{
+	{areaMainland, "nodes in mainland China, what an empty area purges"},
+	{areaOverseas, "nodes outside mainland China"},
+	{areaGlobal, "every node"},
+}
+
+// flushTypes are the accepted values of purge_config.flush_type and per-path flush types
+var flushTypes = []enumValue{
+	{flushTypeFlush, "purge changed resources"},
+	{flushTypeDelete, "purge all resources"},
+}
+
+// batchStrategies are the accepted values of purge_config.batch_strategy
+var batchStrategies = []enumValue{
+	{batchByCount, "split after batch_size paths, or path_batch_size and url_batch_size per purge mode"},
+}
+
+// listings are the enumerations printed by the list command, by name
+var listings = []struct {
+	name   string
+	values func() []enumValue
+}{
+	{"regions", regionValues},
+	{"areas", func() []enumValue { return purgeAreas }},
+	{"flush-types", func() []enumValue { return flushTypes }},
+	{"batch-strategies", func() []enumValue { return batchStrategies }},
+}
+
+// regionValues lists knownRegions, describing where each is located
+func regionValues() []enumValue {
+	values := make([]enumValue, len(knownRegions))
+	for i, region := range knownRegions {
+		switch {
+		case purge.IsFinanceRegion(region):
+			values[i] = enumValue{region, "finance cloud, requires partition finance"}
+		case isMainlandRegion(region):
+			values[i] = enumValue{region, "mainland China"}
+		default:
+			values[i] = enumValue{region, "outside mainland China"}
+		}
+	}
+	return values
+}
+
+// isEnumValue reports whether value is one of values
+func isEnumValue(values []enumValue, value string) bool {
+	for _, v := range values {
+		if v.Value == value {
+			return true
+		}
+	}
+	return false
+}
+
+// choiceList formats values for error messages, as in "a, b or c"
+func choiceList(values []enumValue) string {
+	names := make([]string, len(values))
+	for i, v := range values {
+		names[i] = v.Value
+	}
+	return joinChoices(names)
+}
+
+// describedChoiceList formats values with their descriptions, as in `"a" (first) or "b" (second)`
+func describedChoiceList(values []enumValue) string {
+	names := make([]string, len(values))
+	for i, v := range values {
+		names[i] = fmt.Sprintf("%q (%s)", v.Value, v.Description)
+	}
+	return joinChoices(names)
+}
+
+// joinChoices joins names with commas and a final "or"
+func joinChoices(names []string) string {
+	if len(names) < 2 {
+		return strings.Join(names, "")
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " or " + names[len(names)-1]
+}
+
+// listSummary is the listing printed by the list command in JSON output mode
+type listSummary struct {
+	Name   string      `json:"name"`
+	Values []enumValue `json:"values"`
+}
+
+// listCommand prints the values accepted for one of the enumerated settings
+func listCommand(ctx context.Context, source *configSource, args []string) {
+	fs := newListFlags()
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(exitConfig)
+	}
+
+	for _, listing := range listings {
+		if listing.name != fs.Arg(0) {
+			continue
+		}
+		values := listing.values()
+		printEnumValues(values)
+		out.Result(listSummary{Name: listing.name, Values: values})
+		return
+	}
+	out.Exitf(exitConfig, "Unknown list %q, expected %s", fs.Arg(0), strings.Join(listingNames(), ", "))
+}
+
+// listingNames returns the names of the listings, in the order they are defined
+func listingNames() []string {
+	names := make([]string, len(listings))
+	for i, listing := range listings {
+		names[i] = listing.name
+	}
+	return names
+}
+
+// newListFlags creates the flag set of the list command
+func newListFlags() *flag.FlagSet {
+	return newFlagSet("list", "<"+strings.Join(listingNames(), "|")+">", "Print the values accepted for a setting, as validated by this tool.")
+}
+
+// printEnumValues prints values as a table in text output mode
+func printEnumValues(values []enumValue) {
+	if out.format != outputText || out.quiet {
+		return
+	}
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "VALUE\tDESCRIPTION")
+	for _, v := range values {
+		fmt.Fprintf(table, "%s\t%s\n", v.Value, v.Description)
+	}
+	table.Flush()
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// lockFile is not implemented on this platform, so state files are refused rather than
+// shared unsafely between concurrent runs
+func lockFile(f *os.File, path string) error {
+	return errors.New("file locking is not supported on this platform")
+}
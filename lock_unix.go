@@ -0,0 +1,20 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive advisory lock on f, logging when it has to wait for
+// another run holding the lock on the state file at path
+func lockFile(f *os.File, path string) error {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if !errors.Is(err, syscall.EWOULDBLOCK) {
+		return err
+	}
+	logger.Info("waiting for another run to release the state file", "path", path)
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+}
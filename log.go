@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Supported values for the --log-level flag
+const (
+	logLevelError = "error"
+	logLevelInfo  = "info"
+	logLevelDebug = "debug"
+)
+
+// logger writes diagnostics to stderr, configured from the --log-level flag
+var logger = newLogger(slog.LevelInfo)
+
+// sensitiveLogKeys are attribute keys whose values never reach the log output
+var sensitiveLogKeys = map[string]bool{
+	"secret_key": true,
+	"token":      true,
+}
+
+// parseLogLevel converts a --log-level value into a slog level
+func parseLogLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case logLevelError:
+		return slog.LevelError, nil
+	case logLevelInfo:
+		return slog.LevelInfo, nil
+	case logLevelDebug:
+		return slog.LevelDebug, nil
+	default:
+		return 0, fmt.Errorf("unsupported log level %q, expected %s, %s or %s", level, logLevelError, logLevelInfo, logLevelDebug)
+	}
+}
+
+// newLogger returns a text logger on stderr that redacts credentials
+func newLogger(level slog.Level) *slog.Logger {
+	return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			if sensitiveLogKeys[attr.Key] {
+				return slog.String(attr.Key, "[REDACTED]")
+			}
+			if attr.Key == "secret_id" {
+				return slog.String(attr.Key, maskSecret(attr.Value.String()))
+			}
+			return attr
+		},
+	}))
+}
+
+// maskSecret keeps only the last four characters of a secret so logs can tell keys apart
+func maskSecret(secret string) string {
+	if len(secret) <= 4 {
+		return "****"
+	}
+	return "****" + secret[len(secret)-4:]
+}
@@ -1,142 +1,562 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
+	"log/slog"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
-	cdn "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/cdn/v20180606"
-	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
-	tencentCloudSDKErrors "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/errors"
-	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/profile"
-	"gopkg.in/yaml.v2"
+	"git.ghink.net/ghink/PurgeCOSPathCache/purge"
 )
 
 // Config represents the structure of the configuration file
 type Config struct {
-	TencentCloud struct {
-		SecretID  string `yaml:"secret_id"`
-		SecretKey string `yaml:"secret_key"`
-		Region    string `yaml:"region"`
-	} `yaml:"tencent_cloud"`
-	PurgeConfig struct {
-		Paths     []string `yaml:"paths"`
-		FlushType string   `yaml:"flush_type"`
-		UrlEncode bool     `yaml:"url_encode"`
-		Area      string   `yaml:"area"`
-	} `yaml:"purge_config"`
+	TencentCloud TencentCloudProfiles `yaml:"tencent_cloud" json:"tencent_cloud" toml:"tencent_cloud"`
+	PurgeConfig  struct {
+		PurgeMode string `yaml:"purge_mode" json:"purge_mode" toml:"purge_mode"`
+		// PathEntries are the configured paths, plain URLs or {url, flush_type} objects,
+		// which loadConfig flattens into Paths and FlushTypes
+		PathEntries []pathEntry `yaml:"paths" json:"paths" toml:"paths"`
+		// Paths are the URLs to purge, gathered from paths, paths_file, domains, globs and the sitemap
+		Paths []string `yaml:"-" json:"-" toml:"-"`
+		// FlushTypes holds the flush type of each path entry that overrides FlushType
+		FlushTypes map[string]string `yaml:"-" json:"-" toml:"-"`
+		FlushType  string            `yaml:"flush_type" json:"flush_type" toml:"flush_type"`
+		UrlEncode  urlEncodeSetting  `yaml:"url_encode" json:"url_encode" toml:"url_encode"`
+		// Areas are the configured areas, each purged separately, and Area is the one of
+		// the purge being submitted, set by areaConfig
+		Areas areaList `yaml:"area" json:"area" toml:"area"`
+		Area  string   `yaml:"-" json:"-" toml:"-"`
+		// PathsFile names a newline-delimited file whose entries are appended to Paths
+		PathsFile string `yaml:"paths_file" json:"paths_file" toml:"paths_file"`
+		// PathGlobs are matched under GlobRoot and mapped onto URLPrefix to produce paths
+		PathGlobs []string `yaml:"path_globs" json:"path_globs" toml:"path_globs"`
+		GlobRoot  string   `yaml:"glob_root" json:"glob_root" toml:"glob_root"`
+		URLPrefix string   `yaml:"url_prefix" json:"url_prefix" toml:"url_prefix"`
+		// COSKeys are COS object keys, purged as the URLs they have under CDNBaseURL
+		COSKeys    []string `yaml:"cos_keys" json:"cos_keys" toml:"cos_keys"`
+		CDNBaseURL string   `yaml:"cdn_base_url" json:"cdn_base_url" toml:"cdn_base_url"`
+		// ChangedFiles maps the files under Root modified since purge -since onto BaseURL
+		ChangedFiles struct {
+			Root    string `yaml:"root" json:"root" toml:"root"`
+			BaseURL string `yaml:"base_url" json:"base_url" toml:"base_url"`
+			// DeletionsFile lists deleted files, which have no modification time, relative to Root
+			DeletionsFile string `yaml:"deletions_file" json:"deletions_file" toml:"deletions_file"`
+		} `yaml:"changed_files" json:"changed_files" toml:"changed_files"`
+		// Domains are purged entirely by submitting their root directory
+		Domains []string `yaml:"domains" json:"domains" toml:"domains"`
+		// SitemapURL lists URLs to purge in url mode, optionally only those modified after SitemapLastmodAfter
+		SitemapURL          string `yaml:"sitemap_url" json:"sitemap_url" toml:"sitemap_url"`
+		SitemapLastmodAfter string `yaml:"sitemap_lastmod_after" json:"sitemap_lastmod_after" toml:"sitemap_lastmod_after"`
+		// QueryVariants are query strings purged along with every url mode path that has
+		// none, for cache keys including the query. IgnoreQuery declares that the cache key
+		// ignores query strings, so purging the bare URL suffices.
+		QueryVariants []string `yaml:"query_variants" json:"query_variants" toml:"query_variants"`
+		IgnoreQuery   bool     `yaml:"ignore_query" json:"ignore_query" toml:"ignore_query"`
+		// AllowedDomains, when set, restricts the hosts paths may target; *.example.com matches subdomains
+		AllowedDomains []string `yaml:"allowed_domains" json:"allowed_domains" toml:"allowed_domains"`
+		// LowercaseHost lowercases path hosts and Dedupe drops exact duplicate paths
+		LowercaseHost bool `yaml:"lowercase_host" json:"lowercase_host" toml:"lowercase_host"`
+		Dedupe        bool `yaml:"dedupe" json:"dedupe" toml:"dedupe"`
+		// DedupeWindow skips paths purged this many seconds ago or less, as recorded in --state-file
+		DedupeWindow int `yaml:"dedupe_window" json:"dedupe_window" toml:"dedupe_window"`
+		// WaitTimeout and PollInterval bound the --wait loop, in seconds
+		WaitTimeout  int  `yaml:"wait_timeout" json:"wait_timeout" toml:"wait_timeout"`
+		PollInterval int  `yaml:"poll_interval" json:"poll_interval" toml:"poll_interval"`
+		CheckQuota   bool `yaml:"check_quota" json:"check_quota" toml:"check_quota"`
+		// CheckDomains verifies with DescribeDomains that every path host is a domain of each
+		// profile before purging, at the cost of an extra API call
+		CheckDomains bool `yaml:"check_domains" json:"check_domains" toml:"check_domains"`
+		// PrecheckURLs sends a HEAD request to every URL of a url mode purge and reports those
+		// not answering 2xx or 3xx, failing the run if PrecheckFailOnError is set
+		PrecheckURLs        bool `yaml:"precheck_urls" json:"precheck_urls" toml:"precheck_urls"`
+		PrecheckFailOnError bool `yaml:"precheck_fail_on_error" json:"precheck_fail_on_error" toml:"precheck_fail_on_error"`
+		// WaitForQuota resubmits batches rejected for exhausted quota once DescribePurgeQuota
+		// reports enough again, giving up after QuotaWaitTimeout seconds
+		WaitForQuota     bool `yaml:"wait_for_quota" json:"wait_for_quota" toml:"wait_for_quota"`
+		QuotaWaitTimeout int  `yaml:"quota_wait_timeout" json:"quota_wait_timeout" toml:"quota_wait_timeout"`
+		// BatchStrategy decides where paths are split into API calls, count being the only one
+		BatchStrategy string `yaml:"batch_strategy" json:"batch_strategy" toml:"batch_strategy"`
+		// BatchSize caps the number of paths submitted per API call, PathBatchSize and
+		// URLBatchSize override it for directory and URL purges
+		BatchSize     int `yaml:"batch_size" json:"batch_size" toml:"batch_size"`
+		PathBatchSize int `yaml:"path_batch_size" json:"path_batch_size" toml:"path_batch_size"`
+		URLBatchSize  int `yaml:"url_batch_size" json:"url_batch_size" toml:"url_batch_size"`
+		// Concurrency is the number of batches submitted in parallel
+		Concurrency int `yaml:"concurrency" json:"concurrency" toml:"concurrency"`
+		// MaxPaths aborts a run resolving to more paths than this before any API call
+		MaxPaths int `yaml:"max_paths" json:"max_paths" toml:"max_paths"`
+		// ConfirmThreshold asks for interactive confirmation above this many paths, 0 disables it
+		ConfirmThreshold int `yaml:"confirm_threshold" json:"confirm_threshold" toml:"confirm_threshold"`
+		// PreHook and PostHook are shell commands run right before the purge, which a failed
+		// pre-hook aborts, and after it with the result
+		PreHook  string `yaml:"pre_hook" json:"pre_hook" toml:"pre_hook"`
+		PostHook string `yaml:"post_hook" json:"post_hook" toml:"post_hook"`
+		// RedactPaths shows hashes instead of paths in logs and text output, like -redact
+		RedactPaths bool `yaml:"redact_paths" json:"redact_paths" toml:"redact_paths"`
+	} `yaml:"purge_config" json:"purge_config" toml:"purge_config"`
+	PushConfig struct {
+		Urls      []string `yaml:"urls" json:"urls" toml:"urls"`
+		Area      string   `yaml:"area" json:"area" toml:"area"`
+		UserAgent string   `yaml:"user_agent" json:"user_agent" toml:"user_agent"`
+		Layer     string   `yaml:"layer" json:"layer" toml:"layer"`
+		// UrlEncode is url_encode for the pushed URLs, true, false or auto like purge_config's
+		UrlEncode urlEncodeSetting `yaml:"url_encode" json:"url_encode" toml:"url_encode"`
+	} `yaml:"push_config" json:"push_config" toml:"push_config"`
+	// UrlEncode is the url_encode of purge_config and push_config when they do not set their own
+	UrlEncode urlEncodeSetting `yaml:"url_encode" json:"url_encode" toml:"url_encode"`
+	// Notify posts the outcome of each purge run to a webhook
+	Notify struct {
+		WebhookURL string `yaml:"webhook_url" json:"webhook_url" toml:"webhook_url"`
+		// On selects which runs are reported: always (default), failure or success
+		On string `yaml:"on" json:"on" toml:"on"`
+	} `yaml:"notify" json:"notify" toml:"notify"`
 }
 
-// loadConfig reads and parses the YAML configuration file
-func loadConfig(configPath string) (*Config, error) {
-	// Check if config file exists
-	if _, err := os.Stat(configPath); os.IsNotExist(err) {
-		return nil, fmt.Errorf("config file does not exist: %s", configPath)
+// Supported values for purge_config.purge_mode
+const (
+	purgeModePath = purge.ModePath
+	purgeModeURL  = purge.ModeURL
+)
+
+// Supported values for purge_config.flush_type
+const (
+	flushTypeFlush  = purge.FlushTypeFlush
+	flushTypeDelete = purge.FlushTypeDelete
+)
+
+// maxBatchSize is the number of paths Tencent accepts in a single purge call
+const maxBatchSize = purge.MaxBatchSize
+
+// batchByCount is the batch_strategy applied when unset
+const batchByCount = purge.BatchByCount
+
+// defaultMaxPaths is the max_paths applied when unset
+const defaultMaxPaths = 10000
+
+// maxConcurrency caps parallel batch submissions to stay clear of the API rate limit
+const maxConcurrency = 10
+
+// parseConfig decodes config data as JSON, YAML or TOML based on the file extension; files
+// with any other extension are tried as YAML first, then as JSON. The parsed values are
+// expanded with env, see envExpander.
+func parseConfig(data []byte, ext string, config *Config, env *envExpander) error {
+	switch strings.ToLower(ext) {
+	case ".toml":
+		if err := unmarshalExpandedTOML(data, config, env); err != nil {
+			return fmt.Errorf("failed to parse TOML config: %v", err)
+		}
+	case ".json":
+		if err := unmarshalExpandedJSON(data, config, env); err != nil {
+			return fmt.Errorf("failed to parse JSON config: %v", err)
+		}
+	case ".yaml", ".yml":
+		if err := unmarshalExpandedYAML(data, config, env); err != nil {
+			return fmt.Errorf("failed to parse YAML config: %v", err)
+		}
+	default:
+		yamlErr := unmarshalExpandedYAML(data, config, env)
+		if yamlErr == nil {
+			return nil
+		}
+		*config = Config{}
+		if jsonErr := unmarshalExpandedJSON(data, config, env); jsonErr != nil {
+			return fmt.Errorf("failed to parse config as YAML (%v) or JSON (%v)", yamlErr, jsonErr)
+		}
 	}
+	return nil
+}
 
-	// Read config file
-	data, err := os.ReadFile(configPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %v", err)
+// loadConfig reads, merges and parses the YAML, JSON or TOML configuration files
+func loadConfig(ctx context.Context, source *configSource) (*Config, error) {
+	// An explicit -c always wins, otherwise use the first default location that exists
+	if len(source.files) == 0 && !source.skipDefault {
+		path, err := findDefaultConfig()
+		if err != nil {
+			return nil, err
+		}
+		logger.Info("using configuration file", "path", path)
+		source.files = configPaths{path}
 	}
 
-	// Parse YAML
 	var config Config
-	err = yaml.Unmarshal(data, &config)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse YAML config: %v", err)
+	if err := decodeConfigFiles(source, &config); err != nil {
+		return nil, err
 	}
-
-	return &config, nil
-}
-
-// validateConfig checks if required configuration fields are present
-func validateConfig(config *Config) error {
-	if config.TencentCloud.SecretID == "" {
-		return errors.New("secret_id is required in configuration")
+	// -secret-dir stands in for the secret_dir of a single-account configuration
+	if source.secretDir != "" {
+		switch len(config.TencentCloud) {
+		case 0:
+			config.TencentCloud = TencentCloudProfiles{{}}
+		case 1:
+		default:
+			return nil, errors.New("-secret-dir only applies to a single tencent_cloud profile, set secret_dir per profile instead")
+		}
+		config.TencentCloud[0].SecretDir = source.secretDir
 	}
-	if config.TencentCloud.SecretKey == "" {
-		return errors.New("secret_key is required in configuration")
+	if err := loadCredentialsFiles(config.TencentCloud); err != nil {
+		return nil, err
+	}
+	config.PurgeConfig.Paths, config.PurgeConfig.FlushTypes = flattenPathEntries(config.PurgeConfig.PathEntries)
+
+	// Path sources are merged in a fixed order, so dedupe keeps the same first occurrence
+	// whichever sources are active: inline paths, paths_file, stdin, domains, path_globs,
+	// cos_keys and finally the sitemap
+	if config.PurgeConfig.PathsFile != "" {
+		filePaths, err := readPathsFile(config.PurgeConfig.PathsFile)
+		if err != nil {
+			return nil, err
+		}
+		config.PurgeConfig.Paths = append(config.PurgeConfig.Paths, filePaths...)
 	}
-	if len(config.PurgeConfig.Paths) == 0 {
-		return errors.New("at least one path is required in purge_config.paths")
+	config.PurgeConfig.Paths = append(config.PurgeConfig.Paths, source.stdinPaths...)
+
+	// Default to path purging so existing configs keep working
+	if config.PurgeConfig.PurgeMode == "" {
+		config.PurgeConfig.PurgeMode = purgeModePath
 	}
-	if config.PurgeConfig.FlushType == "" {
-		return errors.New("flush_type is required in purge_config")
+
+	// Purge whole domains through their root directory, validateConflicts rejects them in url mode
+	if len(config.PurgeConfig.Domains) > 0 && config.PurgeConfig.PurgeMode == purgeModePath {
+		domainPaths, err := domainRootPaths(config.PurgeConfig.Domains)
+		if err != nil {
+			return nil, err
+		}
+		logger.Warn("purging entire domains consumes significant purge quota", "domains", len(domainPaths))
+		config.PurgeConfig.Paths = append(config.PurgeConfig.Paths, domainPaths...)
 	}
-	return nil
-}
 
-func main() {
-	// Define command line flag for config file path
-	var configPath string
-	flag.StringVar(&configPath, "c", "config.yaml", "Path to the configuration file")
-	flag.Parse()
+	// Expand local file globs into purge URLs, validateConflicts reports a missing url_prefix
+	if len(config.PurgeConfig.PathGlobs) > 0 && hasHTTPScheme(config.PurgeConfig.URLPrefix) {
+		root := config.PurgeConfig.GlobRoot
+		if root == "" {
+			root = "."
+		}
+		globPaths, err := expandPathGlobs(config.PurgeConfig.PathGlobs, root, config.PurgeConfig.URLPrefix, config.PurgeConfig.PurgeMode)
+		if err != nil {
+			return nil, err
+		}
+		config.PurgeConfig.Paths = append(config.PurgeConfig.Paths, globPaths...)
+	}
 
-	// Load configuration from YAML file
-	config, err := loadConfig(configPath)
-	if err != nil {
-		fmt.Printf("Error loading configuration: %v\n", err)
-		os.Exit(1)
+	// Map object keys onto the CDN, validateConflicts reports a missing or invalid cdn_base_url
+	if len(config.PurgeConfig.COSKeys) > 0 && validateCDNBaseURL(config.PurgeConfig.CDNBaseURL) == nil {
+		config.PurgeConfig.Paths = append(config.PurgeConfig.Paths, cosKeyURLs(config.PurgeConfig.CDNBaseURL, config.PurgeConfig.COSKeys)...)
+	}
+	if config.PurgeConfig.WaitTimeout == 0 {
+		config.PurgeConfig.WaitTimeout = defaultWaitTimeout
+	}
+	if config.PurgeConfig.PollInterval == 0 {
+		config.PurgeConfig.PollInterval = defaultPollInterval
+	}
+	if config.PurgeConfig.MaxPaths == 0 {
+		config.PurgeConfig.MaxPaths = defaultMaxPaths
+	}
+	if config.PurgeConfig.QuotaWaitTimeout == 0 {
+		config.PurgeConfig.QuotaWaitTimeout = defaultQuotaWaitTimeout
+	}
+	for i := range config.TencentCloud {
+		applyProfileDefaults(&config.TencentCloud[i])
+	}
+	if len(config.TencentCloud) == 1 && config.TencentCloud[0].Name == "" {
+		config.TencentCloud[0].Name = defaultProfileName
+	}
+	resolveURLEncode(&config)
+	if config.PurgeConfig.BatchStrategy == "" {
+		config.PurgeConfig.BatchStrategy = batchByCount
+	}
+	if config.PurgeConfig.BatchSize == 0 {
+		config.PurgeConfig.BatchSize = maxBatchSize
+	}
+	if config.PurgeConfig.Concurrency == 0 {
+		config.PurgeConfig.Concurrency = 1
+	}
+	if config.PurgeConfig.DedupeWindow == 0 {
+		config.PurgeConfig.DedupeWindow = defaultDedupeWindow
 	}
 
-	// Validate required configuration fields
-	if err := validateConfig(config); err != nil {
-		fmt.Printf("Configuration validation failed: %v\n", err)
-		os.Exit(1)
+	// Purge the URLs a sitemap lists, capped at one batch as a safety limit. validateConflicts
+	// rejects sitemaps in path mode.
+	if config.PurgeConfig.SitemapURL != "" && config.PurgeConfig.PurgeMode == purgeModeURL {
+		var cutoff time.Time
+		if config.PurgeConfig.SitemapLastmodAfter != "" {
+			var err error
+			if cutoff, err = parseLastmod(config.PurgeConfig.SitemapLastmodAfter); err != nil {
+				return nil, fmt.Errorf("invalid sitemap_lastmod_after: %v", err)
+			}
+		}
+		var urls []string
+		if !source.offline {
+			var err error
+			if urls, err = sitemapURLs(ctx, config.PurgeConfig.SitemapURL, cutoff); err != nil {
+				return nil, err
+			}
+		}
+		if size := batchSize(&config); len(urls) > size {
+			logger.Warn("sitemap lists more URLs than batch_size, purging only the first ones",
+				"urls", len(urls), "batch_size", size)
+			urls = urls[:size]
+		}
+		logger.Info("loaded sitemap", "url", config.PurgeConfig.SitemapURL, "urls", len(urls), "offline", source.offline)
+		config.PurgeConfig.Paths = append(config.PurgeConfig.Paths, urls...)
 	}
 
-	// Create credential using values from configuration file
-	// Using configuration file approach provides better security than hardcoding credentials
-	// and allows for easier environment-specific configurations
-	credential := common.NewCredential(
-		config.TencentCloud.SecretID,
-		config.TencentCloud.SecretKey,
-	)
+	return &config, nil
+}
 
-	// Initialize client profile with optional settings
-	cpf := profile.NewClientProfile()
-	cpf.HttpProfile.Endpoint = "cdn.tencentcloudapi.com"
+// validateConfig checks if required configuration fields are present, reporting every problem at once
+func validateConfig(config *Config) error {
+	return errors.Join(validateProfiles(config.TencentCloud), validatePurgeConfig(config), validateNotifyConfig(config), validateConflicts(config))
+}
 
-	// Create client instance for CDN service
-	// Region is now read from configuration file instead of being hardcoded
-	client, err := cdn.NewClient(credential, config.TencentCloud.Region, cpf)
-	if err != nil {
-		fmt.Printf("Error creating CDN client: %v\n", err)
-		os.Exit(1)
+// validateConflicts checks the options that contradict each other or depend on another one,
+// reporting every conflict at once rather than letting one setting silently win
+func validateConflicts(config *Config) error {
+	var problems []error
+	purgeConfig := &config.PurgeConfig
+	switch purgeConfig.PurgeMode {
+	case purgeModeURL:
+		// URL purging has no flush type, so a configured one would be silently ignored
+		if purgeConfig.FlushType != "" {
+			problems = append(problems, newValueError(purgeConfig.FlushType,
+				"flush_type %q is not supported with purge_mode url, remove it", purgeConfig.FlushType))
+		}
+		if len(purgeConfig.Domains) > 0 {
+			problems = append(problems, errors.New("domains can only be purged with purge_mode path, as directory purges of their root"))
+		}
+	case purgeModePath:
+		if purgeConfig.SitemapURL != "" {
+			problems = append(problems, newValueError(purgeConfig.SitemapURL,
+				"sitemap_url %q can only be used with purge_mode url, a sitemap lists page URLs rather than directories", purgeConfig.SitemapURL))
+		}
+		if purgeConfig.PrecheckURLs {
+			problems = append(problems, errors.New("precheck_urls can only be used with purge_mode url, a directory is not a URL that is served"))
+		}
+	}
+	if len(purgeConfig.QueryVariants) > 0 {
+		if purgeConfig.PurgeMode != purgeModeURL {
+			problems = append(problems, errors.New("query_variants can only be used with purge_mode url, a directory purge covers every query string"))
+		}
+		if purgeConfig.IgnoreQuery {
+			problems = append(problems, errors.New("query_variants has no effect with ignore_query: true, remove one of them"))
+		}
+	}
+	if purgeConfig.PrecheckFailOnError && !purgeConfig.PrecheckURLs {
+		problems = append(problems, errors.New("precheck_fail_on_error requires precheck_urls"))
+	}
+	if purgeConfig.SitemapLastmodAfter != "" && purgeConfig.SitemapURL == "" {
+		problems = append(problems, errors.New("sitemap_lastmod_after requires sitemap_url"))
 	}
 
-	// Create request object for path cache purging
-	request := cdn.NewPurgePathCacheRequest()
+	if len(purgeConfig.PathGlobs) > 0 && !hasHTTPScheme(purgeConfig.URLPrefix) {
+		problems = append(problems, errors.New("url_prefix with an http:// or https:// protocol header is required when path_globs is set"))
+	}
+	if len(purgeConfig.PathGlobs) == 0 && (purgeConfig.URLPrefix != "" || purgeConfig.GlobRoot != "") {
+		problems = append(problems, errors.New("url_prefix and glob_root only apply to path_globs, which is not set"))
+	}
+	if len(purgeConfig.COSKeys) > 0 {
+		problems = append(problems, validateCOSKeys(purgeConfig.COSKeys))
+		if purgeConfig.CDNBaseURL == "" {
+			problems = append(problems, errors.New("cdn_base_url is required when cos_keys is set, object keys are purged as URLs under it"))
+		} else {
+			problems = append(problems, validateCDNBaseURL(purgeConfig.CDNBaseURL))
+		}
+	}
+	if len(purgeConfig.COSKeys) == 0 && purgeConfig.CDNBaseURL != "" {
+		problems = append(problems, errors.New("cdn_base_url only applies to cos_keys, which is not set"))
+	}
 
-	// Configure request parameters from YAML configuration
-	// Paths must include protocol header (http:// or https://)
-	request.Paths = common.StringPtrs(config.PurgeConfig.Paths)
-	request.FlushType = common.StringPtr(config.PurgeConfig.FlushType)
-	request.UrlEncode = common.BoolPtr(config.PurgeConfig.UrlEncode)
+	// Push settings without URLs mean the push list was forgotten
+	pushConfig := &config.PushConfig
+	if len(pushConfig.Urls) == 0 && (pushConfig.Area != "" || pushConfig.UserAgent != "" || pushConfig.Layer != "") {
+		problems = append(problems, errors.New("push_config requires urls when area, user_agent or layer is set"))
+	}
+	return errors.Join(problems...)
+}
 
-	// Area parameter is optional, only set if specified in config
-	if config.PurgeConfig.Area != "" {
-		request.Area = common.StringPtr(config.PurgeConfig.Area)
+// validatePurgeConfig checks the purge_config section
+func validatePurgeConfig(config *Config) error {
+	var problems []error
+	// A sitemap may legitimately list no URL changed since the cutoff, and conflicting
+	// domains or globs are not expanded but reported by validateConflicts
+	pathSources := len(config.PurgeConfig.Domains) + len(config.PurgeConfig.PathGlobs)
+	if len(config.PurgeConfig.Paths) == 0 && config.PurgeConfig.SitemapURL == "" && pathSources == 0 {
+		problems = append(problems, errors.New("at least one path is required in purge_config.paths"))
+	}
+	switch config.PurgeConfig.PurgeMode {
+	case purgeModePath:
+		switch config.PurgeConfig.FlushType {
+		case "":
+			problems = append(problems, fmt.Errorf("flush_type is required in purge_config, -flush-type or %s, expected %s", envFlushType, choiceList(flushTypes)))
+		default:
+			if !skipEnumValidation && !isEnumValue(flushTypes, config.PurgeConfig.FlushType) {
+				problems = append(problems, newValueError(config.PurgeConfig.FlushType,
+					"unsupported flush_type %q, expected %s", config.PurgeConfig.FlushType, describedChoiceList(flushTypes)))
+			}
+		}
+	case purgeModeURL:
+		// The flush type must be left unset, which validateConflicts checks
+	default:
+		problems = append(problems, newValueError(config.PurgeConfig.PurgeMode,
+			"unsupported purge_mode %q, expected %q or %q", config.PurgeConfig.PurgeMode, purgeModePath, purgeModeURL))
+	}
+	problems = append(problems,
+		validatePaths(config.PurgeConfig.Paths, config.PurgeConfig.PurgeMode),
+		validatePathFlushTypes(config.PurgeConfig.PathEntries, config.PurgeConfig.PurgeMode),
+		validateAllowedDomains(config.PurgeConfig.Paths, config.PurgeConfig.AllowedDomains),
+		validateQueryVariants(config.PurgeConfig.QueryVariants),
+		validateAreas(config.PurgeConfig.Areas))
+	if config.PurgeConfig.WaitTimeout < 0 || config.PurgeConfig.PollInterval < 0 {
+		problems = append(problems, errors.New("wait_timeout and poll_interval must not be negative"))
+	}
+	if config.PurgeConfig.MaxPaths < 1 {
+		problems = append(problems, errors.New("max_paths must be at least 1"))
+	}
+	if config.PurgeConfig.QuotaWaitTimeout < 0 {
+		problems = append(problems, errors.New("quota_wait_timeout must not be negative"))
+	}
+	if config.PurgeConfig.DedupeWindow < 0 {
+		problems = append(problems, errors.New("dedupe_window must not be negative"))
+	}
+	if !isEnumValue(batchStrategies, config.PurgeConfig.BatchStrategy) {
+		problems = append(problems, newValueError(config.PurgeConfig.BatchStrategy,
+			"batch_strategy must be %s, got %q", describedChoiceList(batchStrategies), config.PurgeConfig.BatchStrategy))
 	}
+	// The sizes of a purge mode may be 0 to inherit batch_size, which itself defaults when unset
+	for _, size := range []struct {
+		name  string
+		value int
+		min   int
+	}{
+		{"batch_size", config.PurgeConfig.BatchSize, 1},
+		{"path_batch_size", config.PurgeConfig.PathBatchSize, 0},
+		{"url_batch_size", config.PurgeConfig.URLBatchSize, 0},
+	} {
+		if size.value < size.min || size.value > maxBatchSize {
+			lowest := "1"
+			if size.min == 0 {
+				lowest = "0 (inherit batch_size)"
+			}
+			problems = append(problems, fmt.Errorf("%s must be between %s and %d", size.name, lowest, maxBatchSize))
+		}
+	}
+	if config.PurgeConfig.Concurrency < 0 || config.PurgeConfig.Concurrency > maxConcurrency {
+		problems = append(problems, fmt.Errorf("concurrency must be between 1 and %d", maxConcurrency))
+	}
+	return errors.Join(problems...)
+}
 
-	// Execute the API call to purge path cache
-	response, err := client.PurgePathCache(request)
+// validatePushConfig checks the fields required to prefetch URLs after a purge
+func validatePushConfig(config *Config) error {
+	if len(config.PushConfig.Urls) == 0 {
+		return errors.New("at least one url is required in push_config.urls")
+	}
+	return validateArea("push_config.area", config.PushConfig.Area)
+}
 
-	// Handle Tencent Cloud SDK specific errors
-	var tencentCloudSDKError *tencentCloudSDKErrors.TencentCloudSDKError
-	if errors.As(err, &tencentCloudSDKError) {
-		fmt.Printf("API error returned: %s\n", err)
-		os.Exit(1)
+// validateArea checks an optional area setting against the areas the API accepts
+func validateArea(field, area string) error {
+	if area == "" || skipEnumValidation || isEnumValue(purgeAreas, area) {
+		return nil
 	}
+	return newValueError(area, "unsupported %s %q, expected %s", field, area, choiceList(purgeAreas))
+}
 
-	// Handle general errors
+func main() {
+	// Global flags precede the command word and apply to every command
+	var source configSource
+	flag.Var(&source.files, "c", "Path or http(s) URL of a configuration file, repeat to merge several (default: search config.yaml, $XDG_CONFIG_HOME/purgecos/config.yaml, /etc/purgecos/config.yaml)")
+	flag.StringVar(&out.format, "output", outputText, "Output format: text or json")
+	flag.BoolVar(&out.quiet, "quiet", false, "Only print failures, suppressing progress, success output and info logs")
+	flag.BoolVar(&out.quiet, "q", false, "Shorthand for -quiet")
+	colorMode := flag.String("color", colorAuto, "Colorize text output: auto (terminals only, unless NO_COLOR is set), always or never")
+	flag.StringVar(&source.profile, "profile", "", "Merge the named entry of the profiles section over the shared top-level settings")
+	flag.BoolVar(&redactPaths, "redact", false, "Show hashes instead of purge paths in logs and text output, unless -log-level debug")
+	flag.BoolVar(&skipEnumValidation, "no-validate", false, "Skip the checks of flush types, areas, regions and URL schemes against the values this tool knows, for API additions it does not support yet")
+	flag.StringVar(&source.secretDir, "secret-dir", "", "Read secret_id, secret_key and token from the files of this directory, such as a Kubernetes secret mount")
+	flag.BoolVar(&source.strictEnv, "strict-env", false, "Fail if the configuration references an unset environment variable")
+	deadline := flag.Duration("deadline", 0, "Abort the command, including retries and polling, after this long (e.g. 10m), 0 for no limit")
+	showVersion := flag.Bool("version", false, "Print version information and exit")
+	logLevel := flag.String("log-level", logLevelInfo, "Log verbosity: error, info or debug")
+	flag.Usage = func() {
+		output := flag.CommandLine.Output()
+		fmt.Fprintf(output, "Usage: %s [global flags] <command> [flags]\n\nCommands:\n", os.Args[0])
+		for _, cmd := range commands {
+			fmt.Fprintf(output, "  %-10s %s\n", cmd.name, cmd.summary)
+		}
+		fmt.Fprintf(output, "\nRun %s <command> -h for the flags of a command.\n\nGlobal flags:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintf(output, "\n%s", exitCodeHelp)
+	}
+	flag.Parse()
+	if *showVersion {
+		fmt.Println(versionString())
+		return
+	}
+	if out.format != outputText && out.format != outputJSON {
+		format := out.format
+		out.format = outputText
+		out.Exitf(exitConfig, "Unsupported output format %q, expected %q or %q", format, outputText, outputJSON)
+	}
+	color, err := useColor(*colorMode, os.Stdout)
+	if err != nil {
+		out.Exitf(exitConfig, "%v", err)
+	}
+	out.color = color
+	out.errColor, _ = useColor(*colorMode, os.Stderr)
+	out.progressInPlace = isTerminal(os.Stderr)
+	level, err := parseLogLevel(*logLevel)
 	if err != nil {
-		fmt.Printf("Unexpected error: %v\n", err)
-		os.Exit(1)
+		out.Exitf(exitConfig, "%v", err)
+	}
+	// Quiet mode drops informational logs unless a log level was requested explicitly
+	if out.quiet {
+		explicitLevel := false
+		flag.Visit(func(f *flag.Flag) { explicitLevel = explicitLevel || f.Name == "log-level" })
+		if !explicitLevel && level < slog.LevelWarn {
+			level = slog.LevelWarn
+		}
+	}
+	logger = newLogger(level)
+	if skipEnumValidation {
+		logger.Warn("VALIDATION BYPASSED by -no-validate: flush types, areas, regions and URL schemes are not checked, so mistakes are only caught by the API, if at all")
 	}
 
-	// Output response in JSON format
-	fmt.Printf("Purge operation completed successfully: %s\n", response.ToJsonString())
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(exitConfig)
+	}
+	cmd := findCommand(flag.Arg(0))
+	if cmd == nil {
+		fmt.Fprintf(flag.CommandLine.Output(), "Unknown command %q\n\n", flag.Arg(0))
+		flag.Usage()
+		os.Exit(exitConfig)
+	}
+	// An interrupt cancels the context so in-flight requests are aborted and the command can
+	// report what was already submitted. A second interrupt exits immediately.
+	signalCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signalCtx.Done()
+		stop()
+		logger.Warn("interrupted, stopping")
+	}()
+	ctx := signalCtx
+	// The deadline bounds every API call in flight, not only the start of new ones
+	if *deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *deadline)
+		defer cancel()
+	}
+	if err := setupTracing(ctx); err != nil {
+		logger.Warn("tracing disabled", "error", err)
+	}
+	ctx = startRunSpan(ctx, cmd.name)
+	cmd.run(ctx, &source, flag.Args()[1:])
+	finishTracing(exitOK, "")
 }
@@ -0,0 +1,1121 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	tencentCloudSDKErrors "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/errors"
+)
+
+// writeConfig writes a configuration file into a temporary directory and returns its source
+func writeConfig(t *testing.T, name, content string) *configSource {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return &configSource{files: configPaths{path}}
+}
+
+func TestLoadConfigAppliesDefaults(t *testing.T) {
+	source := writeConfig(t, "config.yaml", `
+tencent_cloud:
+  secret_id: id
+  secret_key: key
+purge_config:
+  flush_type: flush
+  paths:
+    - "https://example.com/css/"
+    - {url: "https://example.com/js/", flush_type: delete}
+`)
+	config, err := loadConfig(context.Background(), source)
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+
+	if config.PurgeConfig.PurgeMode != purgeModePath {
+		t.Errorf("PurgeMode = %q, want %q", config.PurgeConfig.PurgeMode, purgeModePath)
+	}
+	if config.PurgeConfig.BatchSize != maxBatchSize || config.PurgeConfig.Concurrency != 1 {
+		t.Errorf("BatchSize = %d, Concurrency = %d", config.PurgeConfig.BatchSize, config.PurgeConfig.Concurrency)
+	}
+	want := []string{"https://example.com/css/", "https://example.com/js/"}
+	if !reflect.DeepEqual(config.PurgeConfig.Paths, want) {
+		t.Errorf("Paths = %q, want %q", config.PurgeConfig.Paths, want)
+	}
+	if got := config.PurgeConfig.FlushTypes["https://example.com/js/"]; got != flushTypeDelete {
+		t.Errorf("flush type of the structured entry = %q, want %q", got, flushTypeDelete)
+	}
+	if len(config.TencentCloud) != 1 || config.TencentCloud[0].Name != defaultProfileName {
+		t.Fatalf("TencentCloud = %+v, want one default profile", config.TencentCloud)
+	}
+	if cloud := config.TencentCloud[0]; *cloud.MaxRetries != 3 || cloud.TimeoutSeconds != defaultTimeoutSeconds {
+		t.Errorf("profile defaults = %d retries, %ds timeout", *cloud.MaxRetries, cloud.TimeoutSeconds)
+	}
+}
+
+func TestLoadConfigParsesJSON(t *testing.T) {
+	source := writeConfig(t, "config.json", `{"purge_config": {"purge_mode": "url", "paths": ["https://example.com/a.js"]}}`)
+	config, err := loadConfig(context.Background(), source)
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if config.PurgeConfig.PurgeMode != purgeModeURL || len(config.PurgeConfig.Paths) != 1 {
+		t.Errorf("PurgeMode = %q, Paths = %q", config.PurgeConfig.PurgeMode, config.PurgeConfig.Paths)
+	}
+}
+
+func TestLoadConfigParsesTOML(t *testing.T) {
+	source := writeConfig(t, "config.toml", `
+[[tencent_cloud]]
+name = "a"
+secret_id = "id"
+secret_key = "key"
+
+[purge_config]
+flush_type = "flush"
+paths = ["https://example.com/css/", {url = "https://example.com/js/", flush_type = "delete"}]
+`)
+	config, err := loadConfig(context.Background(), source)
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if len(config.TencentCloud) != 1 || config.TencentCloud[0].Name != "a" {
+		t.Errorf("TencentCloud = %+v", config.TencentCloud)
+	}
+	if len(config.PurgeConfig.Paths) != 2 || config.PurgeConfig.FlushTypes["https://example.com/js/"] != flushTypeDelete {
+		t.Errorf("Paths = %q, FlushTypes = %v", config.PurgeConfig.Paths, config.PurgeConfig.FlushTypes)
+	}
+
+	source = writeConfig(t, "config.toml", "[purge_config\n")
+	if _, err := loadConfig(context.Background(), source); err == nil || !strings.Contains(err.Error(), "failed to parse TOML config") {
+		t.Errorf("loadConfig error = %v, want a TOML parse error", err)
+	}
+}
+
+func TestLoadConfigSelectsProfile(t *testing.T) {
+	source := writeConfig(t, "config.yaml", `
+tencent_cloud: {secret_id: id, secret_key: key, region: ap-guangzhou}
+purge_config: {flush_type: flush, batch_size: 10, paths: ["https://example.com/shared/"]}
+profiles:
+  staging:
+    purge_config: {paths: ["https://staging.example.com/"]}
+  prod:
+    tencent_cloud: {region: ap-shanghai}
+    purge_config: {flush_type: delete, paths: ["https://example.com/css/", "https://example.com/js/"]}
+`)
+	source.profile = "prod"
+	config, err := loadConfig(context.Background(), source)
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if cloud := config.TencentCloud[0]; cloud.SecretID != "id" || cloud.Region != "ap-shanghai" {
+		t.Errorf("TencentCloud = %+v, want the shared credentials and the profile region", cloud)
+	}
+	if config.PurgeConfig.FlushType != flushTypeDelete || config.PurgeConfig.BatchSize != 10 {
+		t.Errorf("flush_type = %q, batch_size = %d", config.PurgeConfig.FlushType, config.PurgeConfig.BatchSize)
+	}
+	if want := []string{"https://example.com/css/", "https://example.com/js/"}; !reflect.DeepEqual(config.PurgeConfig.Paths, want) {
+		t.Errorf("Paths = %q, want the profile paths %q", config.PurgeConfig.Paths, want)
+	}
+
+	source.profile = "dev"
+	if _, err := loadConfig(context.Background(), source); err == nil || !strings.Contains(err.Error(), "available profiles: prod, staging") {
+		t.Errorf("loadConfig error = %v, want the available profiles", err)
+	}
+}
+
+func TestReadPathsFileParsesJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+		wantErr string
+	}{
+		{"array", `["https://example.com/css/", " ", "https://example.com/js/"]`, []string{"https://example.com/css/", "https://example.com/js/"}, ""},
+		{"object", `{"paths": ["https://example.com/css/"]}`, []string{"https://example.com/css/"}, ""},
+		{"non-string entry", `["https://example.com/css/", 3]`, nil, "entry 1 is a number"},
+		{"object without paths", `{"urls": []}`, nil, "expected an array of strings"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pathsFile := filepath.Join(t.TempDir(), "purge-paths.json")
+			if err := os.WriteFile(pathsFile, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			paths, err := readPathsFile(pathsFile)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("readPathsFile error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(paths, tt.want) {
+				t.Errorf("readPathsFile = %q, %v, want %q", paths, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestChangedFilePaths(t *testing.T) {
+	root := t.TempDir()
+	now := time.Now()
+	for name, modified := range map[string]time.Time{"css/new.css": now, "old.html": now.Add(-time.Hour)} {
+		file := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deletions := filepath.Join(t.TempDir(), "deleted.txt")
+	if err := os.WriteFile(deletions, []byte("js/gone.js\nhttps://other.example.com/gone.png\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var config Config
+	config.PurgeConfig.ChangedFiles.Root = root
+	config.PurgeConfig.ChangedFiles.BaseURL = "https://example.com/"
+	config.PurgeConfig.ChangedFiles.DeletionsFile = deletions
+	paths, err := changedFilePaths(&config, now.Add(-30*time.Minute))
+	if err != nil {
+		t.Fatalf("changedFilePaths: %v", err)
+	}
+	want := []string{"https://example.com/css/new.css", "https://example.com/js/gone.js", "https://other.example.com/gone.png"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("changedFilePaths = %q, want %q", paths, want)
+	}
+}
+
+func TestRunPreHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook commands in this test use sh")
+	}
+	var config Config
+	config.TencentCloud = TencentCloudProfiles{{Name: "a"}}
+	config.PurgeConfig.Paths = []string{"https://example.com/css/"}
+	config.PurgeConfig.PurgeMode = purgeModePath
+
+	input := filepath.Join(t.TempDir(), "input.json")
+	config.PurgeConfig.PreHook = fmt.Sprintf(`test "$PURGECOS_HOOK" = pre && cat > %q`, input)
+	if err := runPreHook(context.Background(), &config); err != nil {
+		t.Fatalf("runPreHook: %v", err)
+	}
+	var received preHookInput
+	if data, err := os.ReadFile(input); err != nil || json.Unmarshal(data, &received) != nil {
+		t.Fatalf("reading hook input: %v", err)
+	}
+	if !reflect.DeepEqual(received.Paths, config.PurgeConfig.Paths) || !reflect.DeepEqual(received.Profiles, []string{"a"}) {
+		t.Errorf("hook input = %+v", received)
+	}
+
+	config.PurgeConfig.PreHook = "exit 3"
+	if err := runPreHook(context.Background(), &config); err == nil || !strings.Contains(err.Error(), "exit status 3") {
+		t.Errorf("runPreHook error = %v, want the exit status", err)
+	}
+}
+
+func TestFailureManifestReplaysFailedBatches(t *testing.T) {
+	var config Config
+	config.TencentCloud = TencentCloudProfiles{{Name: "a"}, {Name: "b"}}
+	config.PurgeConfig.PurgeMode = purgeModePath
+	config.PurgeConfig.FlushType = flushTypeFlush
+	config.PurgeConfig.FlushTypes = map[string]string{"https://example.com/js/": flushTypeDelete}
+	accounts := []accountSummary{
+		{Name: "a", BatchCount: 2, Batches: []batchSummary{
+			{Paths: []string{"https://example.com/css/"}, TaskID: "task-1"},
+			{Paths: []string{"https://example.com/js/"}, Error: "rejected"},
+		}},
+		{Name: "b", BatchCount: 2},
+	}
+
+	manifestPath := filepath.Join(t.TempDir(), "failed.json")
+	if err := writeFailureManifest(manifestPath, newFailureManifest(&config, accounts, time.Now())); err != nil {
+		t.Fatalf("writeFailureManifest: %v", err)
+	}
+	manifest, err := readFailureManifest(manifestPath)
+	if err != nil {
+		t.Fatalf("readFailureManifest: %v", err)
+	}
+
+	var replayed Config
+	replayed.TencentCloud = config.TencentCloud
+	replay, err := applyReplay(&replayed, manifest)
+	if err != nil {
+		t.Fatalf("applyReplay: %v", err)
+	}
+	if want := map[string]map[string][]string{"a": {"": {"https://example.com/js/"}}}; !reflect.DeepEqual(replay, want) {
+		t.Errorf("replay = %v, want %v", replay, want)
+	}
+	if replayed.PurgeConfig.FlushTypes["https://example.com/js/"] != flushTypeDelete {
+		t.Errorf("FlushTypes = %v, want the path flush type kept", replayed.PurgeConfig.FlushTypes)
+	}
+
+	// A run without failures removes the stale manifest
+	if err := writeFailureManifest(manifestPath, newFailureManifest(&config, nil, time.Now())); err != nil {
+		t.Fatalf("writeFailureManifest: %v", err)
+	}
+	if _, err := os.Stat(manifestPath); !os.IsNotExist(err) {
+		t.Errorf("manifest still exists after a successful run: %v", err)
+	}
+}
+
+func TestLoadConfigReadsCredentialsFile(t *testing.T) {
+	credentials := filepath.Join(t.TempDir(), "credentials.yaml")
+	if err := os.WriteFile(credentials, []byte("secret_id: file-id\nsecret_key: file-key\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	source := writeConfig(t, "config.yaml", fmt.Sprintf(`
+tencent_cloud: {secret_id: inline-id, secret_key: inline-key, credentials_file: %q}
+purge_config: {flush_type: flush, paths: ["https://example.com/css/"]}
+`, credentials))
+	t.Setenv(envSecretKey, "env-key")
+
+	config, err := loadConfig(context.Background(), source)
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	resolveCredentials(config)
+	if cloud := config.TencentCloud[0]; cloud.SecretID != "file-id" || cloud.SecretKey != "env-key" {
+		t.Errorf("credentials = %q, %q, want the file id and the environment key", cloud.SecretID, cloud.SecretKey)
+	}
+}
+
+func TestLoadConfigReadsSecretDir(t *testing.T) {
+	dir := t.TempDir()
+	for name, value := range map[string]string{"secret_id": "dir-id\n", "secret_key": "dir-key\r\n"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(value), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	source := writeConfig(t, "config.yaml", `
+tencent_cloud: {secret_id: inline-id, secret_key: inline-key, token: inline-token}
+purge_config: {flush_type: flush, paths: ["https://example.com/css/"]}
+`)
+	source.secretDir = dir
+
+	config, err := loadConfig(context.Background(), source)
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if cloud := config.TencentCloud[0]; cloud.SecretID != "dir-id" || cloud.SecretKey != "dir-key" || cloud.Token != "inline-token" {
+		t.Errorf("credentials = %q, %q, %q, want the trimmed directory values and the inline token", cloud.SecretID, cloud.SecretKey, cloud.Token)
+	}
+
+	os.Remove(filepath.Join(dir, "secret_key"))
+	if _, err := loadConfig(context.Background(), source); err == nil || !strings.Contains(err.Error(), "secret_key") {
+		t.Errorf("loadConfig error = %v, want the missing secret_key", err)
+	}
+}
+
+func TestLoadConfigRejectsMalformedFile(t *testing.T) {
+	source := writeConfig(t, "config.yaml", "purge_config: [unterminated")
+	if _, err := loadConfig(context.Background(), source); err == nil {
+		t.Fatal("loadConfig accepted malformed YAML")
+	}
+
+	for name, data := range map[string]string{
+		"unknown anchor": "purge_config:\n  flush_type: flush\n  paths: *missing\n",
+		"duplicate key":  "purge_config:\n  flush_type: flush\n  flush_type: delete\n",
+	} {
+		_, err := loadConfig(context.Background(), writeConfig(t, "config.yaml", data))
+		if err == nil || !strings.Contains(err.Error(), "line 3") {
+			t.Errorf("%s: loadConfig error = %v, want the line of the problem", name, err)
+		}
+	}
+}
+
+func TestLoadConfigResolvesYAMLAnchors(t *testing.T) {
+	source := writeConfig(t, "config.yaml", `
+shared:
+  static: &static
+    - https://example.com/css/
+    - https://example.com/js/
+  settings: &settings
+    flush_type: delete
+    area: global
+tencent_cloud: {secret_id: id, secret_key: key}
+purge_config:
+  <<: *settings
+  paths: *static
+`)
+	config, err := loadConfig(context.Background(), source)
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if !reflect.DeepEqual(config.PurgeConfig.Paths, []string{"https://example.com/css/", "https://example.com/js/"}) {
+		t.Errorf("Paths = %q, want the anchored list", config.PurgeConfig.Paths)
+	}
+	if config.PurgeConfig.FlushType != flushTypeDelete || !reflect.DeepEqual(config.PurgeConfig.Areas, areaList{areaGlobal}) {
+		t.Errorf("flush_type = %q, area = %q, want the merged settings", config.PurgeConfig.FlushType, config.PurgeConfig.Areas)
+	}
+}
+
+func TestValidateConfigReportsEveryProblem(t *testing.T) {
+	source := writeConfig(t, "config.yaml", `
+purge_config:
+  purge_mode: path
+  flush_type: refresh
+  area: moon
+  paths:
+    - "example.com/css/"
+`)
+	config, err := loadConfig(context.Background(), source)
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	resolveCredentials(config)
+
+	err = validateConfig(config)
+	if err == nil {
+		t.Fatal("validateConfig accepted an invalid configuration")
+	}
+	for _, want := range []string{"secret_id is required", "secret_key is required", `unsupported flush_type "refresh"`, `"moon"`, "missing http:// or https://"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("validation error lacks %q:\n%v", want, err)
+		}
+	}
+}
+
+func TestValidateConfigReportsConflicts(t *testing.T) {
+	source := writeConfig(t, "config.yaml", `
+tencent_cloud: {secret_id: id, secret_key: key}
+purge_config:
+  purge_mode: url
+  flush_type: flush
+  domains: [example.com]
+  url_prefix: "https://example.com"
+  sitemap_lastmod_after: "2024-01-01"
+  paths: ["https://example.com/a.js"]
+push_config: {area: global}
+`)
+	config, err := loadConfig(context.Background(), source)
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+
+	err = validateConfig(config)
+	if err == nil {
+		t.Fatal("validateConfig accepted conflicting options")
+	}
+	for _, want := range []string{"flush_type", "domains", "sitemap_lastmod_after", "url_prefix", "push_config requires urls"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("validation error lacks %q:\n%v", want, err)
+		}
+	}
+}
+
+func TestValidateConfigAcceptsValidConfig(t *testing.T) {
+	source := writeConfig(t, "config.yaml", `
+tencent_cloud: {secret_id: id, secret_key: key}
+purge_config: {flush_type: flush, area: global, paths: ["https://example.com/css/"]}
+`)
+	config, err := loadConfig(context.Background(), source)
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if err := validateConfig(config); err != nil {
+		t.Errorf("validateConfig: %v", err)
+	}
+}
+
+// newMockAccount returns an account whose API calls are answered by handler, along with
+// the decoded bodies of the requests it received
+func newMockAccount(t *testing.T, handler func(action string, body map[string]interface{}) string) (*account, *[]map[string]interface{}) {
+	t.Helper()
+	var bodies []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		var body map[string]interface{}
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Errorf("decoding request body %q: %v", data, err)
+		}
+		bodies = append(bodies, body)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, handler(r.Header.Get("X-TC-Action"), body))
+	}))
+	t.Cleanup(server.Close)
+	return newTestAccount(t, server.URL), &bodies
+}
+
+// newTestAccount returns an account calling the API at serverURL without retries
+func newTestAccount(t *testing.T, serverURL string) *account {
+	t.Helper()
+	maxRetries := 0
+	cloud := &TencentCloudProfile{
+		Name:       defaultProfileName,
+		SecretID:   "id",
+		SecretKey:  "key",
+		MaxRetries: &maxRetries,
+		Endpoint:   strings.TrimPrefix(serverURL, "http://"),
+		Scheme:     "http",
+	}
+	applyProfileDefaults(cloud)
+	acct, err := newAccount(cloud)
+	if err != nil {
+		t.Fatalf("newAccount: %v", err)
+	}
+	return acct
+}
+
+// purgeTestConfig is a valid path purge of the given paths
+func purgeTestConfig(paths ...string) *Config {
+	var config Config
+	config.PurgeConfig.PurgeMode = purgeModePath
+	config.PurgeConfig.FlushType = flushTypeFlush
+	config.PurgeConfig.Paths = paths
+	config.PurgeConfig.BatchSize = maxBatchSize
+	config.PurgeConfig.Concurrency = 1
+	return &config
+}
+
+func TestRunAccountChecksQuotaAndPurges(t *testing.T) {
+	var actions []string
+	acct, bodies := newMockAccount(t, func(action string, body map[string]interface{}) string {
+		actions = append(actions, action)
+		if action == "DescribePurgeQuota" {
+			return `{"Response":{"RequestId":"req-q","PathPurge":[{"Area":"mainland","Available":10,"Total":100,"Batch":100}],"UrlPurge":[]}}`
+		}
+		return `{"Response":{"RequestId":"req-1","TaskId":"task-1"}}`
+	})
+
+	summary, err := runAccount(context.Background(), acct, purgeTestConfig("https://example.com/css/"), runOptions{checkQuota: true})
+	if err != nil {
+		t.Fatalf("runAccount: %v", err)
+	}
+	if !reflect.DeepEqual(actions, []string{"DescribePurgeQuota", "PurgePathCache"}) {
+		t.Errorf("actions = %q", actions)
+	}
+	body := (*bodies)[1]
+	if body["FlushType"] != flushTypeFlush || !reflect.DeepEqual(body["Paths"], []interface{}{"https://example.com/css/"}) {
+		t.Errorf("purge request body = %v", body)
+	}
+	if !reflect.DeepEqual(summary.TaskIDs, []string{"task-1"}) || !reflect.DeepEqual(summary.RequestIDs, []string{"req-1"}) {
+		t.Errorf("summary = %+v", summary)
+	}
+}
+
+func TestRunAccountPurgesEveryArea(t *testing.T) {
+	acct, bodies := newMockAccount(t, func(action string, body map[string]interface{}) string {
+		if body["Area"] == areaOverseas {
+			return `{"Response":{"RequestId":"req-2","Error":{"Code":"InternalError","Message":"overseas unavailable"}}}`
+		}
+		return `{"Response":{"RequestId":"req-1","TaskId":"task-1"}}`
+	})
+
+	config := purgeTestConfig("https://example.com/css/")
+	config.PurgeConfig.Areas = areaList{areaMainland, areaOverseas}
+	summary, err := runAccount(context.Background(), acct, config, runOptions{})
+	if err == nil || !strings.Contains(err.Error(), "area overseas") {
+		t.Fatalf("runAccount error = %v, want the overseas failure", err)
+	}
+	if len(*bodies) != 2 || (*bodies)[0]["Area"] != areaMainland {
+		t.Errorf("request bodies = %v, want one purge per area", *bodies)
+	}
+	if len(summary.Areas) != 2 || summary.Areas[0].Error != "" || summary.Areas[1].FailedBatchCount != 1 {
+		t.Errorf("area summaries = %+v", summary.Areas)
+	}
+	if !reflect.DeepEqual(summary.TaskIDs, []string{"task-1"}) || len(summary.purgedPaths) != 0 {
+		t.Errorf("summary = %+v, want the mainland task and no path purged everywhere", summary)
+	}
+}
+
+func TestRunAccountMapsErrorsToExitCodes(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		want     int
+	}{
+		{"auth failure", `{"Response":{"RequestId":"req-e","Error":{"Code":"AuthFailure.SignatureFailure","Message":"bad"}}}`, exitAuth},
+		{"quota exhausted", `{"Response":{"RequestId":"req-e","Error":{"Code":"LimitExceeded.CdnPurgePathExceedDayLimit","Message":"bad"}}}`, exitQuota},
+		{"other API error", `{"Response":{"RequestId":"req-e","Error":{"Code":"InvalidParameter","Message":"bad"}}}`, exitAPI},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acct, _ := newMockAccount(t, func(string, map[string]interface{}) string { return tt.response })
+			_, err := runAccount(context.Background(), acct, purgeTestConfig("https://example.com/css/"), runOptions{})
+
+			var sdkErr *tencentCloudSDKErrors.TencentCloudSDKError
+			if !errors.As(err, &sdkErr) || sdkErr.RequestId != "req-e" {
+				t.Fatalf("error = %v, want an SDK error with request id req-e", err)
+			}
+			if code := exitCodeFor(err); code != tt.want {
+				t.Errorf("exitCodeFor = %d, want %d", code, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunAccountReportsNetworkFailure(t *testing.T) {
+	// A closed server leaves a port nothing listens on
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	acct := newTestAccount(t, server.URL)
+
+	_, err := runAccount(context.Background(), acct, purgeTestConfig("https://example.com/css/"), runOptions{})
+	if code := exitCodeFor(err); code != exitNetwork {
+		t.Errorf("exitCodeFor(%v) = %d, want %d", err, code, exitNetwork)
+	}
+}
+
+func TestRunAccountWaitsForQuota(t *testing.T) {
+	const quotaExhausted = `{"Response":{"RequestId":"req-e","Error":{"Code":"LimitExceeded.CdnPurgePathExceedDayLimit","Message":"bad"}}}`
+	tests := []struct {
+		name      string
+		available int
+		// rejections is how many purges are rejected for exhausted quota, -1 for all of them
+		rejections int
+		want       int
+	}{
+		{"quota frees up", 10, 1, exitOK},
+		{"timeout elapses", 0, 1, exitQuota},
+		{"quota reported but purges rejected", 10, -1, exitQuota},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			purges := 0
+			acct, _ := newMockAccount(t, func(action string, body map[string]interface{}) string {
+				if action == "DescribePurgeQuota" {
+					return fmt.Sprintf(`{"Response":{"RequestId":"req-q","PathPurge":[{"Area":"mainland","Available":%d,"Total":100,"Batch":100}],"UrlPurge":[]}}`, tt.available)
+				}
+				purges++
+				if tt.rejections < 0 || purges <= tt.rejections {
+					return quotaExhausted
+				}
+				return `{"Response":{"RequestId":"req-1","TaskId":"task-1"}}`
+			})
+			config := purgeTestConfig("https://example.com/css/")
+			config.PurgeConfig.WaitForQuota = true
+			config.PurgeConfig.QuotaWaitTimeout = 1
+
+			summary, err := runAccount(context.Background(), acct, config, runOptions{})
+			if code := exitCodeFor(err); code != tt.want {
+				t.Fatalf("exitCodeFor(%v) = %d, want %d", err, code, tt.want)
+			}
+			// A purge rejected right after quota was reported waits for the next poll
+			if tt.rejections < 0 && purges > 3 {
+				t.Errorf("purge submitted %d times within quota_wait_timeout, want it to back off", purges)
+			}
+			if tt.want == exitOK && !reflect.DeepEqual(summary.TaskIDs, []string{"task-1"}) {
+				t.Errorf("TaskIDs = %q, want the resubmitted task", summary.TaskIDs)
+			}
+		})
+	}
+}
+
+func TestStateFileDiffsLastRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	state, err := openStateFile(path)
+	if err != nil {
+		t.Fatalf("openStateFile: %v", err)
+	}
+	state.rememberRun([]string{"https://example.com/js/", "https://example.com/css/"}, time.Now())
+	if err := state.record(nil, time.Minute, time.Now()); err != nil {
+		t.Fatalf("record: %v", err)
+	}
+	state.Close()
+
+	state, err = openStateFile(path)
+	if err != nil {
+		t.Fatalf("openStateFile: %v", err)
+	}
+	defer state.Close()
+	added, removed, unchanged := state.diffLastRun([]string{"https://example.com/css/", "https://example.com/img/", "https://example.com/img/"})
+	if !reflect.DeepEqual(added, []string{"https://example.com/img/"}) || !reflect.DeepEqual(removed, []string{"https://example.com/js/"}) || unchanged != 1 {
+		t.Errorf("diffLastRun = %v, %v, %d", added, removed, unchanged)
+	}
+}
+
+func TestExpandQueryVariants(t *testing.T) {
+	got := expandQueryVariants([]string{"https://example.com/a.js", "https://example.com/b.js?v=1"}, []string{"v=2", "?lang=en"})
+	want := []string{"https://example.com/a.js", "https://example.com/a.js?v=2", "https://example.com/a.js?lang=en", "https://example.com/b.js?v=1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expandQueryVariants = %q, want %q", got, want)
+	}
+	if err := validateQueryVariants([]string{"v=2", "a b", "%zz"}); len(flattenErrors(err)) != 2 {
+		t.Errorf("validateQueryVariants = %v, want the two invalid variants", err)
+	}
+}
+
+func TestExplainPurge(t *testing.T) {
+	config := &Config{TencentCloud: []TencentCloudProfile{{Name: "XYZ"}}}
+	config.PurgeConfig.PurgeMode = purgeModePath
+	config.PurgeConfig.FlushType = flushTypeFlush
+	config.PurgeConfig.Areas = areaList{areaMainland, areaOverseas}
+	config.PurgeConfig.Paths = []string{"https://example.com/a/", "https://example.com/b/", "https://example.com/c/"}
+	config.PurgeConfig.FlushTypes = map[string]string{"https://example.com/c/": flushTypeDelete}
+	want := "Will mark stale (flush) 2 directory paths and evict (delete) 1 directory path on mainland + overseas nodes for account XYZ; estimated quota cost 3 of the daily directory purge quota of each area"
+	if got := explainPurge(config); got != want {
+		t.Errorf("explainPurge = %q, want %q", got, want)
+	}
+
+	config.PurgeConfig.PurgeMode, config.PurgeConfig.Areas = purgeModeURL, nil
+	config.TencentCloud = append(config.TencentCloud, TencentCloudProfile{Name: "backup"})
+	want = "Will purge 3 URLs on mainland nodes (no area set) for accounts XYZ and backup; estimated quota cost 3 of the daily URL purge quota of each account"
+	if got := explainPurge(config); got != want {
+		t.Errorf("explainPurge = %q, want %q", got, want)
+	}
+}
+
+func TestEncodePath(t *testing.T) {
+	tests := []struct{ path, want string }{
+		{"https://example.com/a/b.js?v=1&x=2", "https://example.com/a/b.js?v=1&x=2"},
+		{"https://example.com/my%20file.js", "https://example.com/my%20file.js"},
+		{"https://example.com/my file.js", "https://example.com/my%20file.js"},
+		{"https://example.com/图片/a.png", "https://example.com/%E5%9B%BE%E7%89%87/a.png"},
+		{"https://example.com/100%/a%2", "https://example.com/100%25/a%252"},
+	}
+	for _, tt := range tests {
+		if got := encodePath(tt.path); got != tt.want {
+			t.Errorf("encodePath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestLoadConfigParsesURLEncodeAuto(t *testing.T) {
+	for value, want := range map[string]urlEncodeSetting{"true": urlEncodeOn, "false": urlEncodeOff, "auto": urlEncodeAuto} {
+		var config Config
+		if err := unmarshalYAML([]byte("purge_config:\n  url_encode: "+value+"\n"), &config); err != nil {
+			t.Fatalf("url_encode %s: %v", value, err)
+		}
+		if config.PurgeConfig.UrlEncode != want {
+			t.Errorf("url_encode %s = %q, want %q", value, config.PurgeConfig.UrlEncode, want)
+		}
+	}
+	var config Config
+	if err := unmarshalYAML([]byte("purge_config:\n  url_encode: sometimes\n"), &config); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("unmarshalYAML error = %v, want the line of url_encode", err)
+	}
+}
+
+func TestStateFileTotalsQuotaUsage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	state, err := openStateFile(path)
+	if err != nil {
+		t.Fatalf("openStateFile: %v", err)
+	}
+	defer state.Close()
+
+	lastYear := time.Date(2025, time.October, 31, 12, 0, 0, 0, time.UTC)
+	state.addQuotaUsage(purgeModePath, 50, lastYear)
+	now := time.Date(2026, time.October, 14, 12, 0, 0, 0, time.UTC)
+	state.addQuotaUsage(purgeModePath, 12, now)
+	state.addQuotaUsage(purgeModeURL, 3, now)
+	if total := state.addQuotaUsage(purgeModePath, 8, now.Add(time.Hour)); total != 20 {
+		t.Errorf("monthly path quota = %d, want 20", total)
+	}
+	if _, ok := state.state.QuotaUsage["2025-10"]; ok {
+		t.Errorf("QuotaUsage kept %v, want months older than a year dropped", state.state.QuotaUsage)
+	}
+}
+
+func TestLoadConfigJoinsCOSKeys(t *testing.T) {
+	source := writeConfig(t, "config.yaml", `
+tencent_cloud: {secret_id: id, secret_key: key}
+purge_config:
+  purge_mode: url
+  cdn_base_url: "https://cdn.example.com/site/"
+  cos_keys: ["static/app.js", "/static/app.css"]
+`)
+	config, err := loadConfig(context.Background(), source)
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	want := []string{"https://cdn.example.com/site/static/app.js", "https://cdn.example.com/site/static/app.css"}
+	if !reflect.DeepEqual(config.PurgeConfig.Paths, want) {
+		t.Errorf("Paths = %q, want %q", config.PurgeConfig.Paths, want)
+	}
+
+	config.PurgeConfig.CDNBaseURL = "cdn.example.com"
+	if err := validateConflicts(config); err == nil || !strings.Contains(err.Error(), "must be an absolute URL") {
+		t.Errorf("validateConflicts = %v, want the relative cdn_base_url rejected", err)
+	}
+}
+
+func TestRunAccountChecksDomains(t *testing.T) {
+	var actions []string
+	acct, _ := newMockAccount(t, func(action string, body map[string]interface{}) string {
+		actions = append(actions, action)
+		if action == "DescribeDomains" {
+			return `{"Response":{"RequestId":"req-d","TotalNumber":2,"Domains":[{"Domain":"example.com"},{"Domain":"*.static.example.com"}]}}`
+		}
+		return `{"Response":{"RequestId":"req-1","TaskId":"task-1"}}`
+	})
+
+	config := purgeTestConfig("https://example.com/css/", "https://img.static.example.com/a/")
+	if _, err := runAccount(context.Background(), acct, config, runOptions{checkDomains: true}); err != nil {
+		t.Fatalf("runAccount: %v", err)
+	}
+	if !reflect.DeepEqual(actions, []string{"DescribeDomains", "PurgePathCache"}) {
+		t.Errorf("actions = %q, want the domain check before the purge", actions)
+	}
+
+	actions = nil
+	config = purgeTestConfig("https://other.org/css/", "https://example.com/js/", "https://other.org/js/")
+	_, err := runAccount(context.Background(), acct, config, runOptions{checkDomains: true})
+	if !errors.Is(err, errUnknownDomains) || !strings.HasSuffix(err.Error(), ": other.org") || exitCodeFor(err) != exitConfig {
+		t.Errorf("runAccount error = %v, want other.org reported as unknown", err)
+	}
+	if len(actions) != 1 {
+		t.Errorf("actions = %q, want nothing purged after the failed check", actions)
+	}
+}
+
+func TestCompletionSpecsListCommandFlags(t *testing.T) {
+	words := map[string]string{}
+	for _, spec := range completionSpecs() {
+		words[spec.name] = spec.words()
+	}
+	if !strings.Contains(words["purge"], "-dry-run") || !strings.Contains(words["purge"], "-check-domains") {
+		t.Errorf("purge completes %q, want its flags", words["purge"])
+	}
+	if !strings.Contains(words["list"], "regions") {
+		t.Errorf("list completes %q, want the listings", words["list"])
+	}
+	for _, cmd := range commands {
+		if name := cmd.flags().Name(); name != cmd.name {
+			t.Errorf("command %s completes the flags of %s", cmd.name, name)
+		}
+	}
+	if !strings.Contains(bashCompletion("PurgeCOSPathCache", nil, completionSpecs()), "complete -o default -F _PurgeCOSPathCache PurgeCOSPathCache") {
+		t.Error("bash script does not register its completion function")
+	}
+}
+
+func TestLoadConfigMergesTOMLFiles(t *testing.T) {
+	source := writeConfig(t, "base.toml", `
+[[tencent_cloud]]
+name = "a"
+secret_id = "id-a"
+secret_key = "key-a"
+
+[purge_config]
+flush_type = "flush"
+paths = ["https://example.com/css/"]
+`)
+	override := writeConfig(t, "override.toml", `
+[[tencent_cloud]]
+name = "b"
+secret_id = "id-b"
+secret_key = "key-b"
+
+[purge_config]
+paths = ["https://example.com/js/"]
+`)
+	source.files = append(source.files, override.files...)
+	config, err := loadConfig(context.Background(), source)
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if len(config.TencentCloud) != 2 || config.TencentCloud[0].Name != "a" || config.TencentCloud[1].SecretID != "id-b" {
+		t.Errorf("TencentCloud = %+v, want the profiles of both files", config.TencentCloud)
+	}
+	if want := []string{"https://example.com/css/", "https://example.com/js/"}; !reflect.DeepEqual(config.PurgeConfig.Paths, want) {
+		t.Errorf("Paths = %q, want %q", config.PurgeConfig.Paths, want)
+	}
+}
+
+func TestLoadConfigMergesPathSourcesInOrder(t *testing.T) {
+	sitemap := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<urlset><url><loc>https://example.com/a.css</loc></url><url><loc>https://example.com/sitemap.css</loc></url></urlset>`)
+	}))
+	defer sitemap.Close()
+	pathsFile := filepath.Join(t.TempDir(), "paths.txt")
+	if err := os.WriteFile(pathsFile, []byte("https://example.com/file.css\nhttps://example.com/b.css\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	source := writeConfig(t, "config.yaml", fmt.Sprintf(`
+tencent_cloud:
+  secret_id: id
+  secret_key: key
+purge_config:
+  purge_mode: url
+  dedupe: true
+  paths:
+    - "https://example.com/b.css"
+    - "https://example.com/a.css"
+  paths_file: %q
+  sitemap_url: %q
+`, pathsFile, sitemap.URL))
+	source.stdinPaths = []string{"https://example.com/stdin.css", "https://example.com/file.css"}
+
+	config, err := loadConfig(context.Background(), source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	normalizeConfigPaths(config, false)
+	want := []string{
+		"https://example.com/b.css",
+		"https://example.com/a.css",
+		"https://example.com/file.css",
+		"https://example.com/stdin.css",
+		"https://example.com/sitemap.css",
+	}
+	if !reflect.DeepEqual(config.PurgeConfig.Paths, want) {
+		t.Errorf("paths = %q, want %q", config.PurgeConfig.Paths, want)
+	}
+}
+
+func TestRunPrecheckReportsURLsNotServed(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			t.Errorf("precheck sent %s, want HEAD", r.Method)
+		}
+		switch r.URL.Path {
+		case "/moved":
+			http.Redirect(w, r, "/missing", http.StatusMovedPermanently)
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer origin.Close()
+
+	config := &Config{}
+	config.PurgeConfig.Paths = []string{origin.URL + "/ok", origin.URL + "/missing", origin.URL + "/moved"}
+	failed := precheckURLs(context.Background(), newPrecheckClient(), config.PurgeConfig.Paths)
+	if len(failed) != 1 || failed[0].url != origin.URL+"/missing" || failed[0].status != http.StatusNotFound {
+		t.Errorf("failed = %+v, want only /missing with 404", failed)
+	}
+
+	if err := runPrecheck(context.Background(), config); err != nil {
+		t.Errorf("runPrecheck without precheck_fail_on_error = %v, want nil", err)
+	}
+	config.PurgeConfig.PrecheckFailOnError = true
+	if err := runPrecheck(context.Background(), config); !errors.Is(err, errPrecheckFailed) || exitCodeFor(err) != exitConfig {
+		t.Errorf("runPrecheck = %v, want errPrecheckFailed", err)
+	}
+}
+
+func TestLoadConfigFallsBackToTopLevelURLEncode(t *testing.T) {
+	source := writeConfig(t, "config.yaml", `
+tencent_cloud:
+  secret_id: id
+  secret_key: key
+url_encode: auto
+purge_config:
+  purge_mode: url
+  url_encode: false
+  paths:
+    - "https://example.com/my file.js"
+push_config:
+  urls:
+    - "https://example.com/my file.js"
+`)
+	config, err := loadConfig(context.Background(), source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.PurgeConfig.UrlEncode != urlEncodeOff || config.PushConfig.UrlEncode != urlEncodeAuto {
+		t.Fatalf("url_encode = %q for purges and %q for pushes, want false and auto", config.PurgeConfig.UrlEncode, config.PushConfig.UrlEncode)
+	}
+	if urls := newPushRequest(config).Urls; *urls[0] != "https://example.com/my%20file.js" {
+		t.Errorf("pushed %q, want the URL encoded", *urls[0])
+	}
+	if newPurgeConfig(config).URLEncode {
+		t.Error("purge asks the API to encode, want purge_config.url_encode: false to win")
+	}
+}
+
+func TestWatchBatcherHoldsBackURLsWithinDedupeWindow(t *testing.T) {
+	now := time.Now()
+	batcher := newWatchBatcher(time.Minute)
+	batcher.add("https://example.com/b.css")
+	batcher.add("https://example.com/a.css")
+	urls, retry := batcher.ready(now)
+	if want := []string{"https://example.com/a.css", "https://example.com/b.css"}; !reflect.DeepEqual(urls, want) || retry != 0 {
+		t.Fatalf("ready = %q, %v, want %q, 0", urls, retry, want)
+	}
+	batcher.purged(urls, now)
+
+	batcher.add("https://example.com/a.css")
+	if urls, retry := batcher.ready(now.Add(20 * time.Second)); len(urls) != 0 || retry != 40*time.Second {
+		t.Errorf("ready within the window = %q, %v, want nothing and 40s", urls, retry)
+	}
+	if urls, _ := batcher.ready(now.Add(time.Minute)); !reflect.DeepEqual(urls, []string{"https://example.com/a.css"}) {
+		t.Errorf("ready after the window = %q, want a.css", urls)
+	}
+}
+
+func TestLoadConfigExpandsEnvInParsedValues(t *testing.T) {
+	t.Setenv("PURGECOS_TEST_REGION", `ap-"guangzhou": x`)
+	t.Setenv("PURGECOS_TEST_RETRIES", "7")
+	source := writeConfig(t, "config.yaml", `
+tencent_cloud:
+  secret_id: id
+  secret_key: "ab$$cd$PURGECOS_TEST_UNSET${PURGECOS_TEST_UNSET}gh"
+  region: "${PURGECOS_TEST_REGION}"
+  max_retries: $PURGECOS_TEST_RETRIES
+purge_config:
+  flush_type: flush
+  paths:
+    - "https://example.com/?a=$1"
+`)
+	config, err := loadConfig(context.Background(), source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cloud := config.TencentCloud[0]
+	if cloud.SecretKey != "ab$cdgh" {
+		t.Errorf("secret_key = %q, want $$ kept as $ and the unset references emptied", cloud.SecretKey)
+	}
+	if cloud.Region != `ap-"guangzhou": x` || cloud.MaxRetries == nil || *cloud.MaxRetries != 7 {
+		t.Errorf("region = %q, max_retries = %v, want the environment values", cloud.Region, cloud.MaxRetries)
+	}
+	if config.PurgeConfig.Paths[0] != "https://example.com/?a=$1" {
+		t.Errorf("path = %q, want $1 left untouched", config.PurgeConfig.Paths[0])
+	}
+
+	for name, content := range map[string]string{
+		"config.json": `{"tencent_cloud": {"secret_id": "id", "secret_key": "key", "region": "$PURGECOS_TEST_REGION"}}`,
+		"config.toml": "[tencent_cloud]\nsecret_id = \"id\"\nsecret_key = \"key\"\nregion = \"$PURGECOS_TEST_REGION\"\n",
+	} {
+		config, err := loadConfig(context.Background(), writeConfig(t, name, content))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if config.TencentCloud[0].Region != `ap-"guangzhou": x` {
+			t.Errorf("%s: region = %q, want the quoted environment value", name, config.TencentCloud[0].Region)
+		}
+	}
+
+	source = writeConfig(t, "config.yaml", "tencent_cloud: {secret_id: $PURGECOS_TEST_UNSET}\n")
+	source.strictEnv = true
+	if _, err := loadConfig(context.Background(), source); err == nil || !strings.Contains(err.Error(), "PURGECOS_TEST_UNSET") {
+		t.Errorf("loadConfig with -strict-env = %v, want the unset variable reported", err)
+	}
+}
+
+func TestLoadConfigDecodesTOMLWithTags(t *testing.T) {
+	t.Setenv("PURGECOS_TEST_SECRET", "env-key")
+	source := writeConfig(t, "config.toml", `
+[[tencent_cloud]]
+name = "a"
+secret_id = "id"
+secret_key = "$PURGECOS_TEST_SECRET"
+max_retries = 5
+requests_per_second = 2
+
+[purge_config]
+purge_mode = "url"
+url_encode = "auto"
+area = "mainland,overseas"
+batch_size = 10
+paths = ["https://example.com/a.css", {url = "https://example.com/b.css"}]
+`)
+	config, err := loadConfig(context.Background(), source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cloud := config.TencentCloud[0]
+	if cloud.SecretKey != "env-key" || cloud.MaxRetries == nil || *cloud.MaxRetries != 5 || cloud.RequestsPerSecond != 2 {
+		t.Errorf("profile = %+v, want the snake_case keys decoded", cloud)
+	}
+	if config.PurgeConfig.UrlEncode != urlEncodeAuto || config.PurgeConfig.BatchSize != 10 ||
+		!reflect.DeepEqual([]string(config.PurgeConfig.Areas), []string{"mainland", "overseas"}) || len(config.PurgeConfig.Paths) != 2 {
+		t.Errorf("purge_config = %+v, want the snake_case keys decoded", config.PurgeConfig)
+	}
+}
+
+func TestCheckPurgeQuotaToleratesPartialEntries(t *testing.T) {
+	acct, _ := newMockAccount(t, func(action string, body map[string]interface{}) string {
+		return `{"Response":{"RequestId":"req-q","PathPurge":[{"Area":"mainland","Total":100},null,{"Available":5}],"UrlPurge":[{}]}}`
+	})
+
+	err := checkPurgeQuota(context.Background(), acct, purgeTestConfig("https://example.com/css/"))
+	if !errors.Is(err, errInsufficientQuota) {
+		t.Errorf("checkPurgeQuota = %v, want errInsufficientQuota for the entry without Available", err)
+	}
+}
+
+func TestRunAccountToleratesPushResponseWithoutIDs(t *testing.T) {
+	acct, _ := newMockAccount(t, func(action string, body map[string]interface{}) string {
+		if action == "PushUrlsCache" {
+			return `{"Response":{}}`
+		}
+		return `{"Response":{"RequestId":"req-1","TaskId":"task-1"}}`
+	})
+	config := purgeTestConfig("https://example.com/css/")
+	config.PushConfig.Urls = []string{"https://example.com/css/a.css"}
+
+	summary, err := runAccount(context.Background(), acct, config, runOptions{push: true})
+	if err != nil {
+		t.Fatalf("runAccount: %v", err)
+	}
+	if summary.PushTaskID != "" || !reflect.DeepEqual(summary.RequestIDs, []string{"req-1"}) {
+		t.Errorf("PushTaskID = %q, RequestIDs = %q, want only the purge request id", summary.PushTaskID, summary.RequestIDs)
+	}
+}
+
+func TestCheckPathDomainsToleratesResponsesWithoutBody(t *testing.T) {
+	for _, response := range []string{`{}`, `{"Response":{"RequestId":"req-d","Domains":[null,{}]}}`} {
+		acct, _ := newMockAccount(t, func(action string, body map[string]interface{}) string {
+			return response
+		})
+		if err := checkPathDomains(context.Background(), acct, []string{"https://example.com/css/"}); !errors.Is(err, errUnknownDomains) {
+			t.Errorf("checkPathDomains with %s = %v, want errUnknownDomains", response, err)
+		}
+	}
+}
+
+func TestSitemapURLsRejectsOversizedFiles(t *testing.T) {
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	writer.Write(make([]byte, maxSitemapBytes+1))
+	writer.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(compressed.Bytes())
+	}))
+	defer server.Close()
+
+	if _, err := sitemapURLs(context.Background(), server.URL, time.Time{}); err == nil || !strings.Contains(err.Error(), "sitemap exceeds 50 MB") {
+		t.Errorf("sitemapURLs = %v, want the size limit reported", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := sitemapURLs(ctx, server.URL, time.Time{}); err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Errorf("sitemapURLs with a cancelled context = %v, want it cancelled", err)
+	}
+}
+
+func TestPollPurgeTaskToleratesPartialEntries(t *testing.T) {
+	acct, _ := newMockAccount(t, func(action string, body map[string]interface{}) string {
+		return `{"Response":{"RequestId":"req-t","PurgeLogs":[{"TaskId":"task-1"},null,{"Status":"fail"}],"TotalCount":3}}`
+	})
+
+	_, _, err := pollPurgeTask(context.Background(), acct, "task-1")
+	if err == nil || !strings.Contains(err.Error(), "task task-1 failed") {
+		t.Errorf("pollPurgeTask = %v, want the entry without Url reported as failed", err)
+	}
+}
+
+func TestValidationErrorsRedactPaths(t *testing.T) {
+	redactPaths = true
+	defer func() { redactPaths = false }()
+
+	secret := "https://other.example.net/private/report.pdf"
+	err := errors.Join(
+		validateAllowedDomains([]string{secret}, []string{"example.com"}),
+		validatePathFlushTypes([]pathEntry{{URL: secret, FlushType: flushTypeDelete}}, purgeModeURL),
+	)
+	if err == nil || strings.Contains(err.Error(), "private") || !strings.Contains(err.Error(), displayPath(secret)) {
+		t.Errorf("validation error %q, want the path redacted", err)
+	}
+}
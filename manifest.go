@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"git.ghink.net/ghink/PurgeCOSPathCache/purge"
+)
+
+// failureManifest records the batches a purge run failed to submit, written by
+// -failure-manifest and resubmitted by -replay. The settings of the run are kept so the
+// replay purges the paths exactly as the original run would have.
+type failureManifest struct {
+	CreatedAt string          `json:"created_at"`
+	PurgeMode string          `json:"purge_mode"`
+	URLEncode bool            `json:"url_encode"`
+	Batches   []manifestBatch `json:"batches"`
+}
+
+// manifestBatch is one failed batch of one profile in one area
+type manifestBatch struct {
+	Profile   string   `json:"profile"`
+	Area      string   `json:"area,omitempty"`
+	FlushType string   `json:"flush_type,omitempty"`
+	Paths     []string `json:"paths"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// newFailureManifest collects the failed batches of every account of a run
+func newFailureManifest(config *Config, accounts []accountSummary, now time.Time) failureManifest {
+	manifest := failureManifest{
+		CreatedAt: now.UTC().Format(time.RFC3339),
+		PurgeMode: config.PurgeConfig.PurgeMode,
+		URLEncode: config.PurgeConfig.UrlEncode == urlEncodeOn,
+		Batches:   []manifestBatch{},
+	}
+	for _, account := range accounts {
+		// A profile that failed before submitting, creating its client for instance, failed
+		// every batch in every area
+		if account.Error != "" && account.BatchCount == 0 && len(account.Batches) == 0 {
+			for _, area := range config.PurgeConfig.Areas.targets() {
+				for _, batch := range purge.Batches(newPurgeConfig(config)) {
+					account.Batches = append(account.Batches, batchSummary{Area: area, Paths: batch, Error: account.Error})
+				}
+			}
+		}
+		for _, batch := range account.Batches {
+			if batch.Error == "" {
+				continue
+			}
+			entry := manifestBatch{Profile: account.Name, Area: batch.Area, Paths: batch.Paths, Error: batch.Error}
+			if manifest.PurgeMode == purgeModePath {
+				entry.FlushType = config.PurgeConfig.FlushType
+				if flushType, ok := config.PurgeConfig.FlushTypes[batch.Paths[0]]; ok {
+					entry.FlushType = flushType
+				}
+			}
+			manifest.Batches = append(manifest.Batches, entry)
+		}
+	}
+	return manifest
+}
+
+// writeFailureManifest writes the manifest of a run with failed batches, or removes a stale
+// one when every batch succeeded so it is not replayed by mistake
+func writeFailureManifest(path string, manifest failureManifest) error {
+	if len(manifest.Batches) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode failure manifest: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// readFailureManifest loads a manifest written by writeFailureManifest
+func readFailureManifest(path string) (*failureManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read failure manifest: %v", err)
+	}
+	var manifest failureManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse failure manifest %s: %v", path, err)
+	}
+	return &manifest, nil
+}
+
+// applyReplay replaces the paths and settings of config with those of the manifest. It
+// returns the paths to purge per profile and area, since a batch may have failed for one
+// profile or in one area only.
+func applyReplay(config *Config, manifest *failureManifest) (map[string]map[string][]string, error) {
+	profiles := make(map[string]bool, len(config.TencentCloud))
+	for _, cloud := range config.TencentCloud {
+		profiles[cloud.Name] = true
+	}
+
+	replay := map[string]map[string][]string{}
+	var areas areaList
+	paths := []string{}
+	flushTypes := map[string]string{}
+	seen := map[string]bool{}
+	for _, batch := range manifest.Batches {
+		if !profiles[batch.Profile] {
+			return nil, fmt.Errorf("failure manifest lists profile %q, which is not configured", batch.Profile)
+		}
+		if replay[batch.Profile] == nil {
+			replay[batch.Profile] = map[string][]string{}
+		}
+		replay[batch.Profile][batch.Area] = append(replay[batch.Profile][batch.Area], batch.Paths...)
+		if batch.Area != "" && !slices.Contains(areas, batch.Area) {
+			areas = append(areas, batch.Area)
+		}
+		for _, path := range batch.Paths {
+			if batch.FlushType != "" {
+				flushTypes[path] = batch.FlushType
+			}
+			if !seen[path] {
+				seen[path] = true
+				paths = append(paths, path)
+			}
+		}
+	}
+
+	config.PurgeConfig.Paths = paths
+	config.PurgeConfig.PathEntries, config.PurgeConfig.FlushTypes = nil, flushTypes
+	config.PurgeConfig.PurgeMode = manifest.PurgeMode
+	config.PurgeConfig.Areas = areas
+	// Paths encoded by url_encode: auto were recorded encoded, only true is replayed
+	config.PurgeConfig.UrlEncode = urlEncodeOff
+	if manifest.URLEncode {
+		config.PurgeConfig.UrlEncode = urlEncodeOn
+	}
+	// Every path carries its flush type, the default only satisfies validation
+	if config.PurgeConfig.FlushType == "" && len(manifest.Batches) > 0 {
+		config.PurgeConfig.FlushType = manifest.Batches[0].FlushType
+	}
+	return replay, nil
+}
+
+// replayConfig returns a copy of config purging only the replayed paths of a profile and
+// area that are still part of the run, in the order of config
+func replayConfig(config *Config, profilePaths []string) *Config {
+	wanted := make(map[string]bool, len(profilePaths))
+	for _, path := range profilePaths {
+		wanted[path] = true
+	}
+	profileConfig := *config
+	profileConfig.PurgeConfig.Paths = nil
+	for _, path := range config.PurgeConfig.Paths {
+		if wanted[path] {
+			profileConfig.PurgeConfig.Paths = append(profileConfig.PurgeConfig.Paths, path)
+		}
+	}
+	return &profileConfig
+}
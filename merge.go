@@ -0,0 +1,531 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// configPaths collects the values of a repeatable -c flag
+type configPaths []string
+
+func (p *configPaths) String() string {
+	return strings.Join(*p, ", ")
+}
+
+func (p *configPaths) Set(value string) error {
+	*p = append(*p, value)
+	return nil
+}
+
+// envConfigToken names the environment variable holding a bearer token for config URLs
+const envConfigToken = "PURGECOS_CONFIG_TOKEN"
+
+// configFetchTimeout bounds the download of a configuration URL
+const configFetchTimeout = 10 * time.Second
+
+// configSource describes where the configuration is loaded from, as set by the global flags
+type configSource struct {
+	files configPaths
+	// strictEnv rejects references to unset environment variables instead of replacing them with an empty string
+	strictEnv bool
+	// offline skips the network lookups that expand paths, such as fetching sitemap_url
+	offline bool
+	// skipDefault starts from an empty configuration instead of searching the default
+	// locations when no file is given
+	skipDefault bool
+	// profile selects an entry of the profiles section to merge over the top-level settings
+	profile string
+	// secretDir sets the secret_dir of the single profile, see loadConfig
+	secretDir string
+	// stdinPaths are the paths piped to purge -, merged after paths_file, see loadConfig
+	stdinPaths []string
+}
+
+// defaultConfigPaths lists the locations searched, in order, when -c is not given
+func defaultConfigPaths() []string {
+	paths := []string{"config.yaml"}
+	// XDG_CONFIG_HOME defaults to ~/.config when unset
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			configHome = filepath.Join(home, ".config")
+		}
+	}
+	if configHome != "" {
+		paths = append(paths, filepath.Join(configHome, "purgecos", "config.yaml"))
+	}
+	return append(paths, filepath.Join("/etc", "purgecos", "config.yaml"))
+}
+
+// findDefaultConfig returns the first default config location that exists
+func findDefaultConfig() (string, error) {
+	candidates := defaultConfigPaths()
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("no config file found, pass -c or create one of: %s", strings.Join(candidates, ", "))
+}
+
+// envExpander replaces ${VAR} and $VAR references in the string values of a parsed
+// configuration with environment variables, turning $$ into a literal $. Values are expanded
+// after parsing, so an environment value can never change the structure of the document.
+// Unset variables are replaced with an empty string, or reported by err with strict set.
+// Shell special parameters such as $1 are not variable names and are left as they are.
+type envExpander struct {
+	strict  bool
+	missing []string
+}
+
+// expand returns value with its references replaced
+func (e *envExpander) expand(value string) string {
+	if !strings.Contains(value, "$") {
+		return value
+	}
+	var expanded strings.Builder
+	for i := 0; i < len(value); {
+		if value[i] != '$' || i+1 == len(value) {
+			expanded.WriteByte(value[i])
+			i++
+			continue
+		}
+		var name, reference string
+		switch {
+		case value[i+1] == '$':
+			expanded.WriteByte('$')
+			i += 2
+			continue
+		case value[i+1] == '{':
+			end := strings.IndexByte(value[i:], '}')
+			if end < 0 {
+				expanded.WriteString(value[i:])
+				return expanded.String()
+			}
+			name, reference = value[i+2:i+end], value[i:i+end+1]
+		default:
+			length := strings.IndexFunc(value[i+1:], func(r rune) bool {
+				return r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r)
+			})
+			if length < 0 {
+				length = len(value) - i - 1
+			}
+			name, reference = value[i+1:i+1+length], value[i:i+1+length]
+		}
+		i += len(reference)
+		if !isEnvName(name) {
+			expanded.WriteString(reference)
+			continue
+		}
+		if env, ok := os.LookupEnv(name); ok {
+			expanded.WriteString(env)
+			continue
+		}
+		if e.strict && !slices.Contains(e.missing, name) {
+			e.missing = append(e.missing, name)
+		}
+	}
+	return expanded.String()
+}
+
+// expandTree expands every string value of a generic configuration tree, mapping keys
+// excluded, and reports whether any changed
+func (e *envExpander) expandTree(value interface{}) (interface{}, bool) {
+	changed := false
+	switch v := value.(type) {
+	case string:
+		expanded := e.expand(v)
+		return expanded, expanded != v
+	case map[string]interface{}:
+		for key, item := range v {
+			var itemChanged bool
+			v[key], itemChanged = e.expandTree(item)
+			changed = changed || itemChanged
+		}
+	case []interface{}:
+		for i, item := range v {
+			var itemChanged bool
+			v[i], itemChanged = e.expandTree(item)
+			changed = changed || itemChanged
+		}
+	case []map[string]interface{}:
+		// TOML decodes an array of tables as a list of mappings
+		for _, item := range v {
+			_, itemChanged := e.expandTree(item)
+			changed = changed || itemChanged
+		}
+	}
+	return value, changed
+}
+
+// expandNode expands the scalar values of a YAML document, mapping keys excluded. An
+// expanded plain scalar is resolved again, so max_retries: $RETRIES still decodes as a
+// number. Aliases share the node of their anchor, which is expanded once.
+func (e *envExpander) expandNode(node *yaml.Node) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if expanded := e.expand(node.Value); expanded != node.Value {
+			node.Value = expanded
+			if node.Style == 0 {
+				node.Tag = ""
+			}
+		}
+	case yaml.MappingNode:
+		for i := 1; i < len(node.Content); i += 2 {
+			e.expandNode(node.Content[i])
+		}
+	default:
+		for _, child := range node.Content {
+			e.expandNode(child)
+		}
+	}
+}
+
+// err reports the unset variables a strict expander met
+func (e *envExpander) err() error {
+	if len(e.missing) > 0 {
+		return fmt.Errorf("config references unset environment variables: %s", strings.Join(e.missing, ", "))
+	}
+	return nil
+}
+
+// unmarshalExpandedTOML decodes a TOML document into out with its toml tags, after
+// expanding its string values with env. The document is only encoded again when a value
+// was expanded.
+func unmarshalExpandedTOML(data []byte, out interface{}, env *envExpander) error {
+	var tree map[string]interface{}
+	if err := toml.Unmarshal(data, &tree); err != nil {
+		return err
+	}
+	if _, changed := env.expandTree(tree); changed {
+		var err error
+		if data, err = toml.Marshal(tree); err != nil {
+			return err
+		}
+	}
+	return toml.Unmarshal(data, out)
+}
+
+// unmarshalExpandedJSON decodes a JSON document into out after expanding its string values
+// with env. Numbers are kept as written, so large integers survive re-encoding.
+func unmarshalExpandedJSON(data []byte, out interface{}, env *envExpander) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var tree interface{}
+	if err := decoder.Decode(&tree); err != nil {
+		return err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return errors.New("invalid data after the top-level value")
+	}
+	tree, changed := env.expandTree(tree)
+	if changed {
+		var err error
+		if data, err = json.Marshal(tree); err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(data, out)
+}
+
+// isEnvName reports whether name is a valid environment variable name
+func isEnvName(name string) bool {
+	for i, r := range name {
+		if r != '_' && !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// readConfigFile reads one configuration file, or downloads it when given an http(s) URL.
+// It returns the extension that selects the parser.
+func readConfigFile(configPath string) ([]byte, string, error) {
+	if hasHTTPScheme(configPath) {
+		return fetchConfig(configPath)
+	}
+
+	// Check if config file exists
+	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+		return nil, "", fmt.Errorf("config file does not exist: %s", configPath)
+	}
+
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read config file: %v", err)
+	}
+	return data, filepath.Ext(configPath), nil
+}
+
+// fetchConfig downloads a configuration URL, sending the bearer token from PURGECOS_CONFIG_TOKEN
+// if set. The format follows the Content-Type, falling back to the extension of the URL path.
+func fetchConfig(configURL string) ([]byte, string, error) {
+	request, err := http.NewRequest(http.MethodGet, configURL, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid config URL: %v", err)
+	}
+	if token := os.Getenv(envConfigToken); token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := &http.Client{Timeout: configFetchTimeout}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to fetch config: %v", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("failed to fetch config %s: %s", configURL, response.Status)
+	}
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read config response: %v", err)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(response.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json":
+		return data, ".json", nil
+	case "application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml":
+		return data, ".yaml", nil
+	case "application/toml":
+		return data, ".toml", nil
+	}
+	ext := ""
+	if parsed, err := url.Parse(configURL); err == nil {
+		ext = path.Ext(parsed.Path)
+	}
+	return data, ext, nil
+}
+
+// decodeConfigFiles parses the configuration files in order into config. Several files are
+// deep-merged first: mappings merge key by key, lists are appended and other values of later
+// files replace earlier ones. Keys left empty (null) in a later file keep the earlier value.
+// The selected profile is then applied over the merged tree by selectConfigProfile.
+func decodeConfigFiles(source *configSource, config *Config) error {
+	files := source.files
+	if len(files) == 1 && source.profile == "" {
+		data, ext, err := readConfigFile(files[0])
+		if err != nil {
+			return err
+		}
+		env := &envExpander{strict: source.strictEnv}
+		if err := parseConfig(data, ext, config, env); err != nil {
+			return fmt.Errorf("%s: %w", files[0], err)
+		}
+		if err := env.err(); err != nil {
+			return fmt.Errorf("%s: %w", files[0], err)
+		}
+		return nil
+	}
+
+	merged := map[string]interface{}{}
+	for _, file := range files {
+		data, ext, err := readConfigFile(file)
+		if err != nil {
+			return err
+		}
+		env := &envExpander{strict: source.strictEnv}
+		tree, err := parseConfigTree(data, ext, env)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		if err := env.err(); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		merged = mergeConfigValues(merged, tree).(map[string]interface{})
+	}
+
+	if source.profile != "" {
+		var err error
+		if merged, err = selectConfigProfile(merged, source.profile); err != nil {
+			return err
+		}
+	}
+	return decodeConfigTree(merged, config)
+}
+
+// selectConfigProfile merges the named entry of the profiles section over the top-level
+// settings shared by every profile. Mappings merge key by key like several files do, but
+// the lists and other values of the profile replace the shared ones, so a profile can
+// define its own paths or tencent_cloud list.
+func selectConfigProfile(tree map[string]interface{}, name string) (map[string]interface{}, error) {
+	profiles, _ := tree["profiles"].(map[string]interface{})
+	profile, ok := profiles[name]
+	if !ok {
+		if len(profiles) == 0 {
+			return nil, fmt.Errorf("profile %q not found: the configuration defines no profiles", name)
+		}
+		names := make([]string, 0, len(profiles))
+		for profileName := range profiles {
+			names = append(names, profileName)
+		}
+		slices.Sort(names)
+		return nil, fmt.Errorf("profile %q not found, available profiles: %s", name, strings.Join(names, ", "))
+	}
+	delete(tree, "profiles")
+	if profile == nil {
+		return tree, nil
+	}
+	overrides, ok := profile.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("profile %q must be a mapping", name)
+	}
+	logger.Info("using configuration profile", "profile", name)
+	return overrideConfigValues(tree, overrides).(map[string]interface{}), nil
+}
+
+// overrideConfigValues merges override into base like mergeConfigValues, except that
+// lists replace instead of appending
+func overrideConfigValues(base, override interface{}) interface{} {
+	if override == nil {
+		return base
+	}
+	if o, ok := override.(map[string]interface{}); ok {
+		if b, ok := base.(map[string]interface{}); ok {
+			for key, item := range o {
+				b[key] = overrideConfigValues(b[key], item)
+			}
+			return b
+		}
+	}
+	return override
+}
+
+// decodeTOMLValue decodes a value handed to a toml.Unmarshaler into out, with the toml tags
+// of out, by encoding it again under a key of its own
+func decodeTOMLValue(value, out interface{}) error {
+	data, err := toml.Marshal(map[string]interface{}{"value": value})
+	if err != nil {
+		return err
+	}
+	var wrapper map[string]toml.Primitive
+	metadata, err := toml.Decode(string(data), &wrapper)
+	if err != nil {
+		return err
+	}
+	return metadata.PrimitiveDecode(wrapper["value"], out)
+}
+
+// decodeConfigTree decodes a generic configuration tree into config. The tree is round-tripped
+// through JSON so that it decodes with the regular field tags and custom unmarshalers.
+func decodeConfigTree(tree map[string]interface{}, config *Config) error {
+	data, err := json.Marshal(tree)
+	if err != nil {
+		return fmt.Errorf("failed to encode merged config: %v", err)
+	}
+	if err := json.Unmarshal(data, config); err != nil {
+		return fmt.Errorf("failed to decode merged config: %v", err)
+	}
+	return nil
+}
+
+// parseConfigTree parses a configuration file into a generic tree, choosing the format like
+// parseConfig, and expands its values with env
+func parseConfigTree(data []byte, ext string, env *envExpander) (map[string]interface{}, error) {
+	var tree interface{}
+	switch strings.ToLower(ext) {
+	case ".json":
+		if err := json.Unmarshal(data, &tree); err != nil {
+			return nil, fmt.Errorf("failed to parse JSON config: %v", err)
+		}
+		tree, _ = env.expandTree(tree)
+	case ".yaml", ".yml":
+		if err := unmarshalExpandedYAML(data, &tree, env); err != nil {
+			return nil, fmt.Errorf("failed to parse YAML config: %v", err)
+		}
+	case ".toml":
+		var mapping map[string]interface{}
+		if err := toml.Unmarshal(data, &mapping); err != nil {
+			return nil, fmt.Errorf("failed to parse TOML config: %v", err)
+		}
+		tree, _ = env.expandTree(mapping)
+	default:
+		if yamlErr := unmarshalExpandedYAML(data, &tree, env); yamlErr != nil {
+			tree = nil
+			if jsonErr := json.Unmarshal(data, &tree); jsonErr != nil {
+				return nil, fmt.Errorf("failed to parse config as YAML (%v) or JSON (%v)", yamlErr, jsonErr)
+			}
+			tree, _ = env.expandTree(tree)
+		}
+	}
+
+	if tree == nil {
+		return map[string]interface{}{}, nil
+	}
+	mapping, ok := normalizeConfigTree(tree).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("config must be a mapping at the top level")
+	}
+	return mapping, nil
+}
+
+// normalizeConfigTree converts the map[interface{}]interface{} nodes yaml.v3 produces for
+// mappings with non-string keys into map[string]interface{}, and the []map[string]interface{}
+// TOML produces for arrays of tables into []interface{}, so the tree can be merged and
+// encoded as JSON
+func normalizeConfigTree(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		mapping := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			mapping[fmt.Sprint(key)] = normalizeConfigTree(item)
+		}
+		return mapping
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = normalizeConfigTree(item)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeConfigTree(item)
+		}
+		return v
+	case []map[string]interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = normalizeConfigTree(item)
+		}
+		return list
+	default:
+		return v
+	}
+}
+
+// mergeConfigValues merges override into base following the rules of decodeConfigFiles
+func mergeConfigValues(base, override interface{}) interface{} {
+	if override == nil {
+		return base
+	}
+	switch o := override.(type) {
+	case map[string]interface{}:
+		if b, ok := base.(map[string]interface{}); ok {
+			for key, item := range o {
+				b[key] = mergeConfigValues(b[key], item)
+			}
+			return b
+		}
+	case []interface{}:
+		if b, ok := base.([]interface{}); ok {
+			return append(b, o...)
+		}
+	}
+	return override
+}
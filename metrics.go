@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Metric names written to the --metrics-file, in Prometheus textfile collector format
+const (
+	metricPurgeTotal       = "cos_purge_total"
+	metricPurgeFailedTotal = "cos_purge_failed_total"
+	metricPurgePaths       = "cos_purge_paths"
+	metricLastSuccess      = "cos_purge_last_success_timestamp"
+)
+
+// readMetrics returns the unlabelled samples of an existing metrics file, so counters can
+// continue from the previous run. A missing or unreadable file yields no samples.
+func readMetrics(path string) map[string]float64 {
+	samples := make(map[string]float64)
+	file, err := os.Open(path)
+	if err != nil {
+		return samples
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if value, err := strconv.ParseFloat(fields[1], 64); err == nil {
+			samples[fields[0]] = value
+		}
+	}
+	return samples
+}
+
+// writeMetrics records the outcome of a purge run in the metrics file. The file is replaced
+// atomically so the node_exporter textfile collector never reads a partial file.
+func writeMetrics(path string, failed bool, paths int, now time.Time) error {
+	samples := readMetrics(path)
+	samples[metricPurgeTotal]++
+	if failed {
+		samples[metricPurgeFailedTotal]++
+	} else {
+		samples[metricLastSuccess] = float64(now.Unix())
+	}
+
+	var b strings.Builder
+	writeMetric(&b, metricPurgeTotal, "counter", "Purge runs attempted.", samples[metricPurgeTotal])
+	writeMetric(&b, metricPurgeFailedTotal, "counter", "Purge runs that failed for at least one profile.", samples[metricPurgeFailedTotal])
+	writeMetric(&b, metricPurgePaths, "gauge", "Paths submitted by the last purge run.", float64(paths))
+	writeMetric(&b, metricLastSuccess, "gauge", "Unix time of the last fully successful purge run.", samples[metricLastSuccess])
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.WriteString(b.String()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	// CreateTemp uses mode 0600, but the collector usually runs as another user
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// writeMetric appends one metric with its HELP and TYPE lines
+func writeMetric(b *strings.Builder, name, metricType, help string, value float64) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", name, help, name, metricType, name, strconv.FormatFloat(value, 'f', -1, 64))
+}
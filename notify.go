@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Supported values for notify.on
+const (
+	notifyAlways  = "always"
+	notifyFailure = "failure"
+	notifySuccess = "success"
+)
+
+// notifyTimeout bounds the webhook request so a slow receiver cannot hold up the run
+const notifyTimeout = 5 * time.Second
+
+// notification is the JSON payload posted to notify.webhook_url. Text carries a one-line
+// summary for chat webhooks that only display a text field.
+type notification struct {
+	Status    string   `json:"status"`
+	TaskIDs   []string `json:"task_ids"`
+	PathCount int      `json:"path_count"`
+	Error     string   `json:"error,omitempty"`
+	Timestamp string   `json:"timestamp"`
+	Text      string   `json:"text"`
+}
+
+// validateNotifyConfig checks the optional notify section
+func validateNotifyConfig(config *Config) error {
+	switch config.Notify.On {
+	case "", notifyAlways, notifyFailure, notifySuccess:
+	default:
+		return newValueError(config.Notify.On, "unsupported notify.on %q, expected %s, %s or %s",
+			config.Notify.On, notifyAlways, notifyFailure, notifySuccess)
+	}
+	if config.Notify.WebhookURL != "" && !hasHTTPScheme(config.Notify.WebhookURL) {
+		return newValueError(config.Notify.WebhookURL, "notify.webhook_url %q must start with http:// or https://", config.Notify.WebhookURL)
+	}
+	return nil
+}
+
+// shouldNotify reports whether a run with the given outcome triggers the webhook
+func shouldNotify(on string, failed bool) bool {
+	switch on {
+	case notifyFailure:
+		return failed
+	case notifySuccess:
+		return !failed
+	default:
+		return true
+	}
+}
+
+// sendNotification posts the outcome of a purge run to the configured webhook
+func sendNotification(webhookURL string, summary *runSummary) error {
+	payload := notification{
+		Status:    "success",
+		TaskIDs:   summary.TaskIDs,
+		PathCount: summary.PathCount,
+		Error:     summary.Error,
+		Timestamp: summary.Timestamp,
+		Text:      fmt.Sprintf("Purge of %d paths succeeded, task ids: %s", summary.PathCount, strings.Join(summary.TaskIDs, ", ")),
+	}
+	if summary.Error != "" {
+		payload.Status = "failure"
+		payload.Text = fmt.Sprintf("Purge of %d paths failed: %s", summary.PathCount, summary.Error)
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: notifyTimeout}
+	response, err := client.Post(webhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", response.Status)
+	}
+	return nil
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	cdn "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/cdn/v20180606"
+
+	"git.ghink.net/ghink/PurgeCOSPathCache/purge"
+)
+
+// Supported values for the --output flag
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// printer writes progress, results and errors in the selected output format.
+// Results go to stdout and failures to stderr, except that JSON mode prints every
+// outcome, failed or not, as a single object on stdout.
+type printer struct {
+	format string
+	// quiet suppresses progress and success output, leaving only failures
+	quiet bool
+	// color and errColor enable ANSI colors on stdout and stderr respectively
+	color    bool
+	errColor bool
+	// progressInPlace rewrites progress lines in place, set when stderr is a terminal
+	progressInPlace bool
+}
+
+// out is the printer shared by the whole run, configured from the --output flag
+var out = &printer{format: outputText}
+
+// Printf prints a human-readable progress or success line to stdout, suppressed in JSON mode
+// so stdout stays parseable and in quiet mode
+func (p *printer) Printf(format string, args ...interface{}) {
+	if p.format == outputText && !p.quiet {
+		fmt.Printf(format, args...)
+	}
+}
+
+// Successf prints a human-readable success line to stdout in green, like Printf
+func (p *printer) Successf(format string, args ...interface{}) {
+	p.Printf("%s", paint(p.color, ansiGreen, fmt.Sprintf(format, args...)))
+}
+
+// Warnf prints a human-readable warning line to stderr in yellow, like Errorf, which quiet
+// mode suppresses like Printf
+func (p *printer) Warnf(format string, args ...interface{}) {
+	if p.format == outputText && !p.quiet {
+		fmt.Fprint(os.Stderr, paint(p.errColor, ansiYellow, fmt.Sprintf(format, args...)))
+	}
+}
+
+// Errorf prints a human-readable failure line to stderr in red, which quiet mode keeps
+func (p *printer) Errorf(format string, args ...interface{}) {
+	if p.format == outputText {
+		fmt.Fprint(os.Stderr, paint(p.errColor, ansiRed, fmt.Sprintf(format, args...)))
+	}
+}
+
+// Result prints the JSON result of a successful command, unless in text or quiet mode
+func (p *printer) Result(v interface{}) {
+	if p.format == outputJSON && !p.quiet {
+		p.JSON(v)
+	}
+}
+
+// JSON prints v as a single JSON object
+func (p *printer) JSON(v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		fmt.Printf("{\"error\":%q}\n", fmt.Sprintf("failed to encode output: %v", err))
+		return
+	}
+	fmt.Println(string(data))
+}
+
+// Exitf prints an error in the selected output format and exits with the given code
+func (p *printer) Exitf(code int, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	if p.format == outputJSON {
+		p.JSON(map[string]string{"error": message})
+	} else {
+		p.Errorf("%s\n", message)
+	}
+	exit(code, message)
+}
+
+// Fail prints a failed run, as the summary in JSON mode or the message otherwise,
+// and exits with the given code
+func (p *printer) Fail(code int, message string, summary interface{}) {
+	if p.format == outputJSON {
+		p.JSON(summary)
+	} else {
+		p.Errorf("%s\n", message)
+	}
+	exit(code, message)
+}
+
+// exit ends the run with the given exit code, flushing the trace spans first
+func exit(code int, message string) {
+	finishTracing(code, message)
+	os.Exit(code)
+}
+
+// runSummary is the result object printed in JSON output mode, aggregating
+// the task and request ids of every profile
+type runSummary struct {
+	Timestamp  string   `json:"timestamp"`
+	RequestIDs []string `json:"request_ids"`
+	// FailedRequestIDs are the request ids of rejected API calls, to quote in support tickets
+	FailedRequestIDs []string `json:"failed_request_ids,omitempty"`
+	TaskIDs          []string `json:"task_ids"`
+	PathCount        int      `json:"path_count"`
+	FlushType        string   `json:"flush_type,omitempty"`
+	Area             string   `json:"area,omitempty"`
+	// AccountCount, BatchCount and FailedBatchCount total the batches of every profile
+	AccountCount     int `json:"account_count"`
+	BatchCount       int `json:"batch_count"`
+	FailedBatchCount int `json:"failed_batch_count"`
+	// QuotaUsed totals the quota of PurgeMode consumed by every profile, and
+	// MonthlyQuotaUsed the quota recorded in -state-file for the month so far
+	PurgeMode        string `json:"purge_mode,omitempty"`
+	QuotaUsed        int    `json:"quota_used"`
+	MonthlyQuotaUsed int    `json:"monthly_quota_used,omitempty"`
+	// DurationMS is the wall-clock time from the first API call to the end of the run
+	DurationMS int64 `json:"duration_ms"`
+	// Accounts is left out by -summary-only
+	Accounts []accountSummary `json:"accounts,omitempty"`
+	Error    string           `json:"error,omitempty"`
+}
+
+// emptyRunSummary is the result of a run that had no paths to purge
+func emptyRunSummary() runSummary {
+	return runSummary{Timestamp: time.Now().UTC().Format(time.RFC3339), TaskIDs: []string{}, RequestIDs: []string{}, Accounts: []accountSummary{}}
+}
+
+// add totals the outcome of one profile into the run summary
+func (s *runSummary) add(account *accountSummary) {
+	s.Accounts = append(s.Accounts, *account)
+	s.TaskIDs = append(s.TaskIDs, account.TaskIDs...)
+	s.RequestIDs = append(s.RequestIDs, account.RequestIDs...)
+	s.FailedRequestIDs = append(s.FailedRequestIDs, account.FailedRequestIDs...)
+	s.AccountCount++
+	s.BatchCount += account.BatchCount
+	s.FailedBatchCount += account.FailedBatchCount
+	s.QuotaUsed += account.QuotaUsed
+}
+
+// printTotals prints the aggregated outcome of the run as text
+func (s *runSummary) printTotals() {
+	out.Printf("Purged %d paths in %d batches across %d accounts: %d batches succeeded, %d failed\n",
+		s.PathCount, s.BatchCount, s.AccountCount, s.BatchCount-s.FailedBatchCount, s.FailedBatchCount)
+	if len(s.TaskIDs) > 0 {
+		out.Printf("Task ids: %s\n", strings.Join(s.TaskIDs, ", "))
+	}
+}
+
+// printCompletion prints the final line of a successful run, with its timing, for CI logs
+// to grep
+func (s *runSummary) printCompletion(areaCount int) {
+	areas := "areas"
+	if areaCount == 1 {
+		areas = "area"
+	}
+	out.Printf("Purged %d paths in %d batches across %d %s in %.1fs (request ids: %s)\n",
+		s.PathCount, s.BatchCount, areaCount, areas, float64(s.DurationMS)/1000, strings.Join(s.RequestIDs, ", "))
+}
+
+// printQuotaUsage prints the quota the run consumed, and the month so far when recorded
+func (s *runSummary) printQuotaUsage() {
+	line := fmt.Sprintf("Quota used: %d %ss", s.QuotaUsed, purgeQuotaName(s.PurgeMode))
+	if s.MonthlyQuotaUsed > 0 {
+		line += fmt.Sprintf(", %d this month as recorded in the state file", s.MonthlyQuotaUsed)
+	}
+	out.Printf("%s\n", line)
+}
+
+// accountSummary is the outcome of the run for a single profile
+type accountSummary struct {
+	Name             string   `json:"name"`
+	RequestIDs       []string `json:"request_ids"`
+	FailedRequestIDs []string `json:"failed_request_ids,omitempty"`
+	TaskIDs          []string `json:"task_ids"`
+	PushTaskID       string   `json:"push_task_id,omitempty"`
+	BatchCount       int      `json:"batch_count"`
+	FailedBatchCount int      `json:"failed_batch_count"`
+	// QuotaUsed is the number of paths of the successful batches in every area, each
+	// counting once against the daily quota
+	QuotaUsed int `json:"quota_used"`
+	// Batches details every submitted batch, filled in by -verbose
+	Batches []batchSummary `json:"batches,omitempty"`
+	// Areas splits the outcome by area when several are purged
+	Areas []areaSummary `json:"areas,omitempty"`
+	Error string        `json:"error,omitempty"`
+	// purgedPaths are the paths of the batches the profile submitted successfully
+	purgedPaths []string
+}
+
+// areaSummary is the outcome of the purge of one area by a profile
+type areaSummary struct {
+	Area             string   `json:"area"`
+	TaskIDs          []string `json:"task_ids"`
+	BatchCount       int      `json:"batch_count"`
+	FailedBatchCount int      `json:"failed_batch_count"`
+	Error            string   `json:"error,omitempty"`
+}
+
+// batchSummary is the outcome of one batch, reported by -verbose and for runs with a failed batch
+type batchSummary struct {
+	// Batch is the one-based position of the batch in the run
+	Batch int `json:"batch"`
+	// Area is the area the batch was submitted to, when one is set
+	Area      string   `json:"area,omitempty"`
+	Paths     []string `json:"paths"`
+	TaskID    string   `json:"task_id,omitempty"`
+	RequestID string   `json:"request_id,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// newBatchSummary converts the result of the batch at the zero-based index
+func newBatchSummary(index int, batch purge.BatchResult) batchSummary {
+	summary := batchSummary{Batch: index + 1, Paths: batch.Paths, TaskID: batch.TaskID, RequestID: batch.RequestID}
+	if batch.Err != nil {
+		summary.Error = purge.DescribeError(batch.Err)
+	}
+	return summary
+}
+
+// newAccountSummary returns an empty summary for the given profile
+func newAccountSummary(cloud *TencentCloudProfile) *accountSummary {
+	return &accountSummary{
+		Name:       cloud.Name,
+		RequestIDs: []string{},
+		TaskIDs:    []string{},
+	}
+}
+
+// diffSummary lists the paths -diff found added and removed since the last recorded run
+type diffSummary struct {
+	// PreviousRun is when the compared run was recorded, empty when there was none
+	PreviousRun string   `json:"previous_run,omitempty"`
+	Added       []string `json:"added"`
+	Removed     []string `json:"removed"`
+	Unchanged   int      `json:"unchanged"`
+}
+
+// dryRunSummary lists the requests a dry run would submit in JSON output mode
+type dryRunSummary struct {
+	Requests    []json.RawMessage `json:"requests"`
+	PushRequest json.RawMessage   `json:"push_request,omitempty"`
+}
+
+// renderSummary lists the API requests printed by the render command in JSON output mode
+type renderSummary struct {
+	PurgeRequests []renderedRequest `json:"purge_requests"`
+	PushRequest   *renderedRequest  `json:"push_request,omitempty"`
+}
+
+// renderedRequest is one API request built from the configuration
+type renderedRequest struct {
+	Action string `json:"action"`
+	// Batch is the one-based position of a purge request among the batches
+	Batch int `json:"batch,omitempty"`
+	// Area is the area a purge request is submitted to, when one is set
+	Area      string          `json:"area,omitempty"`
+	Paths     int             `json:"paths"`
+	FlushType string          `json:"flush_type,omitempty"`
+	Request   json.RawMessage `json:"request"`
+}
+
+// statusSummary lists the purge task entries printed by the status command in JSON output mode
+type statusSummary struct {
+	Tasks []taskSummary `json:"tasks"`
+	Error string        `json:"error,omitempty"`
+}
+
+// taskSummary is the status of one URL of a purge task
+type taskSummary struct {
+	Profile    string `json:"profile"`
+	TaskID     string `json:"task_id"`
+	URL        string `json:"url"`
+	Status     string `json:"status"`
+	PurgeType  string `json:"purge_type"`
+	FlushType  string `json:"flush_type,omitempty"`
+	CreateTime string `json:"create_time"`
+}
+
+// newTaskSummary converts a task entry returned by DescribePurgeTasks
+func newTaskSummary(profileName string, task *cdn.PurgeTask) taskSummary {
+	return taskSummary{
+		Profile:    profileName,
+		TaskID:     stringValue(task.TaskId),
+		URL:        stringValue(task.Url),
+		Status:     stringValue(task.Status),
+		PurgeType:  stringValue(task.PurgeType),
+		FlushType:  stringValue(task.FlushType),
+		CreateTime: stringValue(task.CreateTime),
+	}
+}
+
+// stringValue dereferences an optional response field, returning "" when it is absent
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// intValue dereferences an optional numeric response field, returning 0 when it is absent
+func intValue(n *int64) int64 {
+	if n == nil {
+		return 0
+	}
+	return *n
+}
+
+// checkSummary lists the results of the check command in JSON output mode
+type checkSummary struct {
+	Accounts []accountCheck `json:"accounts"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// accountCheck is the result of the checks of a single profile
+type accountCheck struct {
+	Name     string        `json:"name"`
+	Endpoint string        `json:"endpoint"`
+	Checks   []checkResult `json:"checks"`
+}
+
+// checkResult is the outcome of one check: pass, fail or skip
+type checkResult struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// quotaSummary lists the purge quota printed by the quota command in JSON output mode
+type quotaSummary struct {
+	Accounts []accountQuota `json:"accounts"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// accountQuota is the purge quota of a single profile
+type accountQuota struct {
+	Name      string       `json:"name"`
+	PathPurge []quotaEntry `json:"path_purge"`
+	UrlPurge  []quotaEntry `json:"url_purge"`
+}
+
+// quotaEntry is the quota of one purge type in one area
+type quotaEntry struct {
+	Area      string `json:"area"`
+	Batch     int64  `json:"batch"`
+	Total     int64  `json:"total"`
+	Available int64  `json:"available"`
+	Used      int64  `json:"used"`
+}
+
+// newAccountQuota converts the quota returned by DescribePurgeQuota
+func newAccountQuota(profileName string, quota *cdn.DescribePurgeQuotaResponseParams) accountQuota {
+	return accountQuota{
+		Name:      profileName,
+		PathPurge: newQuotaEntries(quota.PathPurge),
+		UrlPurge:  newQuotaEntries(quota.UrlPurge),
+	}
+}
+
+func newQuotaEntries(entries []*cdn.Quota) []quotaEntry {
+	converted := make([]quotaEntry, 0, len(entries))
+	for _, entry := range entries {
+		if entry == nil {
+			continue
+		}
+		converted = append(converted, quotaEntry{
+			Area:      stringValue(entry.Area),
+			Batch:     intValue(entry.Batch),
+			Total:     intValue(entry.Total),
+			Available: intValue(entry.Available),
+			Used:      intValue(entry.Total) - intValue(entry.Available),
+		})
+	}
+	return converted
+}
+
+// appendRecord appends v as one JSON line to the file at path, creating the file and its
+// parent directories if needed, so repeated runs build up a history
+func appendRecord(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode record: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,609 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// pathEntry is one entry of purge_config.paths, either a plain URL or an object
+// carrying its own flush type
+type pathEntry struct {
+	URL string `yaml:"url" json:"url" toml:"url"`
+	// FlushType overrides purge_config.flush_type for this path when set
+	FlushType string `yaml:"flush_type" json:"flush_type" toml:"flush_type"`
+}
+
+// UnmarshalYAML accepts both the plain string and the {url, flush_type} mapping form
+func (e *pathEntry) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*e = pathEntry{URL: node.Value}
+		return nil
+	}
+
+	type plain pathEntry
+	if err := node.Decode((*plain)(e)); err != nil {
+		return err
+	}
+	if e.URL == "" {
+		return nodeErrorf(node, "paths entry is missing url")
+	}
+	return nil
+}
+
+// UnmarshalJSON accepts both the plain string and the {"url", "flush_type"} object form
+func (e *pathEntry) UnmarshalJSON(data []byte) error {
+	var plainURL string
+	if err := json.Unmarshal(data, &plainURL); err == nil {
+		*e = pathEntry{URL: plainURL}
+		return nil
+	}
+
+	type plain pathEntry
+	if err := json.Unmarshal(data, (*plain)(e)); err != nil {
+		return err
+	}
+	if e.URL == "" {
+		return errors.New("paths entry is missing url")
+	}
+	return nil
+}
+
+// UnmarshalTOML accepts both the plain string and the {url, flush_type} inline table form
+func (e *pathEntry) UnmarshalTOML(value interface{}) error {
+	if plainURL, ok := value.(string); ok {
+		*e = pathEntry{URL: plainURL}
+		return nil
+	}
+
+	type plain pathEntry
+	if err := decodeTOMLValue(value, (*plain)(e)); err != nil {
+		return err
+	}
+	if e.URL == "" {
+		return errors.New("paths entry is missing url")
+	}
+	return nil
+}
+
+// flattenPathEntries splits the configured entries into their URLs and the flush types of
+// the entries that set one. A URL listed more than once keeps its first flush type, like
+// dedupe keeps its first occurrence.
+func flattenPathEntries(entries []pathEntry) ([]string, map[string]string) {
+	var paths []string
+	flushTypes := make(map[string]string)
+	for _, entry := range entries {
+		paths = append(paths, entry.URL)
+		if _, seen := flushTypes[entry.URL]; !seen && entry.FlushType != "" {
+			flushTypes[entry.URL] = entry.FlushType
+		}
+	}
+	return paths, flushTypes
+}
+
+// rekeyFlushTypes maps the per-path flush types onto the paths as rewritten by normalize,
+// so they keep applying once the paths themselves have been normalized
+func rekeyFlushTypes(flushTypes map[string]string, normalize func(string) string) map[string]string {
+	rekeyed := make(map[string]string, len(flushTypes))
+	for path, flushType := range flushTypes {
+		path = normalize(path)
+		if _, seen := rekeyed[path]; !seen {
+			rekeyed[path] = flushType
+		}
+	}
+	return rekeyed
+}
+
+// validatePathFlushTypes checks the flush types set on individual paths entries, which
+// only path purges accept
+func validatePathFlushTypes(entries []pathEntry, purgeMode string) error {
+	var invalid []error
+	for _, entry := range entries {
+		switch {
+		case entry.FlushType == "":
+		case purgeMode == purgeModeURL:
+			invalid = append(invalid, newValueError(entry.URL, "flush_type of path %q is only supported in path purge mode", displayPath(entry.URL)))
+		case !skipEnumValidation && !isEnumValue(flushTypes, entry.FlushType):
+			invalid = append(invalid, newValueError(entry.URL, "unsupported flush_type %q for path %q, expected %s", entry.FlushType, displayPath(entry.URL), choiceList(flushTypes)))
+		}
+	}
+	return errors.Join(invalid...)
+}
+
+// parsePathList reads newline-delimited paths, skipping blank lines and # comments
+func parsePathList(r io.Reader) ([]string, error) {
+	var paths []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		paths = append(paths, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+// parseJSONPathList parses a JSON array of path strings, or an object holding the array
+// under a paths key. Blank entries are skipped like blank lines in the text format.
+func parseJSONPathList(data []byte) ([]string, error) {
+	var document interface{}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	if object, ok := document.(map[string]interface{}); ok {
+		document = object["paths"]
+	}
+	entries, ok := document.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected an array of strings or an object with a paths array")
+	}
+
+	var paths []string
+	for i, entry := range entries {
+		path, ok := entry.(string)
+		if !ok {
+			return nil, fmt.Errorf("entry %d is %s, not a string", i, describeJSONValue(entry))
+		}
+		if path = strings.TrimSpace(path); path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+// describeJSONValue names the JSON type of a decoded value for error messages
+func describeJSONValue(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "a boolean"
+	case float64:
+		return "a number"
+	case []interface{}:
+		return "an array"
+	case map[string]interface{}:
+		return "an object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+// readPathsFile loads the paths listed in a paths file: a JSON array when the file ends
+// in .json, otherwise newline-delimited text
+func readPathsFile(pathsFile string) ([]string, error) {
+	// Check if paths file exists
+	if _, err := os.Stat(pathsFile); os.IsNotExist(err) {
+		return nil, fmt.Errorf("paths file does not exist: %s", pathsFile)
+	}
+
+	if strings.EqualFold(filepath.Ext(pathsFile), ".json") {
+		data, err := os.ReadFile(pathsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open paths file: %v", err)
+		}
+		paths, err := parseJSONPathList(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse JSON paths file %s: %v", pathsFile, err)
+		}
+		return paths, nil
+	}
+
+	file, err := os.Open(pathsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open paths file: %v", err)
+	}
+	defer file.Close()
+
+	paths, err := parsePathList(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read paths file: %v", err)
+	}
+	return paths, nil
+}
+
+// hasHTTPScheme reports whether path starts with the http:// or https:// protocol header
+func hasHTTPScheme(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// withDefaultScheme prepends https:// to path when it lacks a protocol header
+func withDefaultScheme(path string) string {
+	if !hasHTTPScheme(path) {
+		return "https://" + path
+	}
+	return path
+}
+
+// addDefaultScheme prepends https:// to every path lacking a protocol header
+func addDefaultScheme(paths []string) {
+	for i, path := range paths {
+		paths[i] = withDefaultScheme(path)
+	}
+}
+
+// validatePaths checks that every path carries a protocol header and, for URL purges,
+// parses as a URL with a host, reporting all invalid entries at once
+func validatePaths(paths []string, purgeMode string) error {
+	var invalid []error
+	for _, path := range paths {
+		if !hasHTTPScheme(path) && !skipEnumValidation {
+			invalid = append(invalid, newValueError(path, "invalid path %q: missing http:// or https://", displayPath(path)))
+			continue
+		}
+		if purgeMode == purgeModeURL {
+			if parsed, err := url.Parse(path); err != nil || parsed.Host == "" {
+				invalid = append(invalid, newValueError(path, "invalid path %q: not a valid URL", displayPath(path)))
+			}
+		}
+	}
+	return errors.Join(invalid...)
+}
+
+// expandQueryVariants returns the paths followed, for each path without a query string, by
+// the path with every variant appended. Paths that carry a query are purged as given.
+func expandQueryVariants(paths, variants []string) []string {
+	expanded := make([]string, 0, len(paths)*(len(variants)+1))
+	for _, path := range paths {
+		expanded = append(expanded, path)
+		if strings.ContainsAny(path, "?#") {
+			continue
+		}
+		for _, variant := range variants {
+			expanded = append(expanded, path+"?"+strings.TrimPrefix(variant, "?"))
+		}
+	}
+	return expanded
+}
+
+// validateQueryVariants checks that every query variant yields a valid URL query
+func validateQueryVariants(variants []string) error {
+	var invalid []error
+	for i, variant := range variants {
+		query := strings.TrimPrefix(variant, "?")
+		switch {
+		case query == "":
+			invalid = append(invalid, fmt.Errorf("query_variants entry %d is empty", i+1))
+		case strings.ContainsAny(query, "# \t"):
+			invalid = append(invalid, newValueError(variant, "invalid query variant %q: fragments and whitespace are not part of a query", variant))
+		default:
+			if _, err := url.ParseQuery(query); err != nil {
+				invalid = append(invalid, newValueError(variant, "invalid query variant %q: %v", variant, err))
+			}
+		}
+	}
+	return errors.Join(invalid...)
+}
+
+// maxWarningExamples caps the paths quoted by a warning about many paths
+const maxWarningExamples = 3
+
+// warnPathModes warns about paths that look mismatched with the purge mode: a directory, ending
+// in a slash, purged as a URL only purges that URL and not the files under it, while a file
+// purged as a directory purges nothing but the paths it prefixes. Neither is rejected since
+// both can be intended, a site root in url mode for instance.
+func warnPathModes(paths []string, purgeMode string) {
+	var mismatched []string
+	for _, path := range paths {
+		parsed, err := url.Parse(path)
+		if err != nil {
+			continue
+		}
+		switch purgeMode {
+		case purgeModeURL:
+			if strings.HasSuffix(parsed.Path, "/") && parsed.Path != "/" {
+				mismatched = append(mismatched, path)
+			}
+		case purgeModePath:
+			name := parsed.Path[strings.LastIndex(parsed.Path, "/")+1:]
+			if strings.Contains(name, ".") {
+				mismatched = append(mismatched, path)
+			}
+		}
+	}
+	if len(mismatched) == 0 {
+		return
+	}
+
+	examples := displayPaths(mismatched[:min(len(mismatched), maxWarningExamples)])
+	if purgeMode == purgeModeURL {
+		logger.Warn("directory paths in url mode only purge the directory URL itself, use purge_mode path to purge the files under them",
+			"paths", len(mismatched), "examples", examples)
+		return
+	}
+	logger.Warn("file paths in path mode are purged as directory prefixes, use purge_mode url to purge the files themselves",
+		"paths", len(mismatched), "examples", examples)
+}
+
+// expandPathGlobs matches each glob against the files under root and maps every match onto
+// urlPrefix. Directory matches become directory purges (with a trailing slash) in path mode
+// and are skipped in URL mode, which can only purge files.
+func expandPathGlobs(globs []string, root, urlPrefix, purgeMode string) ([]string, error) {
+	var paths []string
+	for _, glob := range globs {
+		matches, err := filepath.Glob(filepath.Join(root, glob))
+		if err != nil {
+			return nil, fmt.Errorf("invalid path glob %q: %v", glob, err)
+		}
+
+		expanded := 0
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return nil, fmt.Errorf("failed to stat %s: %v", match, err)
+			}
+			if info.IsDir() && purgeMode == purgeModeURL {
+				continue
+			}
+
+			rel, err := filepath.Rel(root, match)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve %s against %s: %v", match, root, err)
+			}
+			path := fileURL(urlPrefix, rel)
+			if info.IsDir() {
+				path += "/"
+			}
+			paths = append(paths, path)
+			expanded++
+		}
+
+		// A glob that matches nothing usually means the config has gone stale
+		if expanded == 0 {
+			return nil, fmt.Errorf("path glob %q matched nothing under %s", glob, root)
+		}
+	}
+	return paths, nil
+}
+
+// fileURL maps a path relative to a local root onto the URL prefix serving that root
+func fileURL(urlPrefix, rel string) string {
+	return strings.TrimSuffix(urlPrefix, "/") + "/" + strings.TrimPrefix(filepath.ToSlash(rel), "/")
+}
+
+// changedSinceCutoff returns the modification time after which purge -since selects files:
+// since before now, or the modification time of the marker file
+func changedSinceCutoff(since time.Duration, markerFile string, now time.Time) (time.Time, error) {
+	if since > 0 && markerFile != "" {
+		return time.Time{}, errors.New("-since and -since-file cannot be combined")
+	}
+	if markerFile == "" {
+		return now.Add(-since), nil
+	}
+	info, err := os.Stat(markerFile)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read the -since-file marker: %v", err)
+	}
+	return info.ModTime(), nil
+}
+
+// changedFilePaths returns the URLs of the files under changed_files.root modified after
+// cutoff, followed by those of the files listed in changed_files.deletions_file. Deletions
+// may also be listed as full URLs.
+func changedFilePaths(config *Config, cutoff time.Time) ([]string, error) {
+	changed := config.PurgeConfig.ChangedFiles
+	if changed.Root == "" || !hasHTTPScheme(changed.BaseURL) {
+		return nil, errors.New("-since requires purge_config.changed_files.root and a base_url starting with http:// or https://")
+	}
+
+	var paths []string
+	err := filepath.WalkDir(changed.Root, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || !info.ModTime().After(cutoff) {
+			return nil
+		}
+		rel, err := filepath.Rel(changed.Root, file)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, fileURL(changed.BaseURL, rel))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk %s: %v", changed.Root, err)
+	}
+	logger.Info("selected changed files", "root", changed.Root, "since", cutoff.Format(time.RFC3339), "files", len(paths))
+
+	if changed.DeletionsFile != "" {
+		deleted, err := readPathsFile(changed.DeletionsFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range deleted {
+			if !hasHTTPScheme(path) {
+				path = fileURL(changed.BaseURL, path)
+			}
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+// lowercaseHost lowercases the scheme and host of a URL without touching the path or query,
+// which are case-sensitive parts of the cache key
+func lowercaseHost(path string) string {
+	schemeEnd := strings.Index(path, "://")
+	if schemeEnd < 0 {
+		return path
+	}
+	hostStart := schemeEnd + len("://")
+	hostEnd := len(path)
+	if i := strings.IndexAny(path[hostStart:], "/?#"); i >= 0 {
+		hostEnd = hostStart + i
+	}
+	return strings.ToLower(path[:hostEnd]) + path[hostEnd:]
+}
+
+// normalizePath trims whitespace around path and optionally lowercases its host
+func normalizePath(path string, lowerHost bool) string {
+	path = strings.TrimSpace(path)
+	if lowerHost {
+		path = lowercaseHost(path)
+	}
+	return path
+}
+
+// normalizePaths normalizes every path with normalizePath, dropping entries left empty
+func normalizePaths(paths []string, lowerHost bool) []string {
+	normalized := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if path = normalizePath(path, lowerHost); path != "" {
+			normalized = append(normalized, path)
+		}
+	}
+	return normalized
+}
+
+// dedupePaths removes exact duplicates, keeping the first occurrence of each path,
+// and returns the number of entries removed
+func dedupePaths(paths []string) ([]string, int) {
+	seen := make(map[string]bool, len(paths))
+	unique := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+		unique = append(unique, path)
+	}
+	return unique, len(paths) - len(unique)
+}
+
+// hostnamePattern matches a bare hostname such as cdn.example.com
+var hostnamePattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?)*$`)
+
+// domainRootPaths turns each bare hostname into a root directory purge entry
+func domainRootPaths(domains []string) ([]string, error) {
+	var invalid, paths []string
+	for _, domain := range domains {
+		domain = strings.TrimSpace(domain)
+		if !hostnamePattern.MatchString(domain) {
+			invalid = append(invalid, fmt.Sprintf("%q", domain))
+			continue
+		}
+		paths = append(paths, "http://"+domain+"/")
+	}
+	if len(invalid) > 0 {
+		return nil, fmt.Errorf("domains must be bare hostnames without scheme or path: %s", strings.Join(invalid, ", "))
+	}
+	return paths, nil
+}
+
+// cosKeyURLs joins every COS object key onto the CDN base URL, with exactly one slash
+// between them whatever slashes the base URL ends and the key starts with
+func cosKeyURLs(baseURL string, keys []string) []string {
+	base := strings.TrimRight(baseURL, "/")
+	urls := make([]string, len(keys))
+	for i, key := range keys {
+		urls[i] = base + "/" + strings.TrimLeft(strings.TrimSpace(key), "/")
+	}
+	return urls
+}
+
+// validateCDNBaseURL checks that cdn_base_url is an absolute http:// or https:// URL that
+// object keys can be appended to
+func validateCDNBaseURL(baseURL string) error {
+	parsed, err := url.Parse(baseURL)
+	if err != nil || !hasHTTPScheme(baseURL) || parsed.Host == "" {
+		return newValueError(baseURL, "cdn_base_url %q must be an absolute URL with an http:// or https:// protocol header and a host", baseURL)
+	}
+	if parsed.RawQuery != "" || parsed.Fragment != "" {
+		return newValueError(baseURL, "cdn_base_url %q must not have a query string or fragment, object keys are appended to it", baseURL)
+	}
+	return nil
+}
+
+// validateCOSKeys checks that no entry of cos_keys is empty, which would purge the base URL
+func validateCOSKeys(keys []string) error {
+	for i, key := range keys {
+		if strings.Trim(strings.TrimSpace(key), "/") == "" {
+			return fmt.Errorf("cos_keys[%d] is empty, which would purge cdn_base_url itself", i)
+		}
+	}
+	return nil
+}
+
+// domainAllowed reports whether host matches one of the allowed domain patterns. A pattern
+// is either an exact hostname or a wildcard such as *.example.com, which matches any
+// subdomain but not example.com itself.
+func domainAllowed(host string, allowed []string) bool {
+	host = strings.ToLower(host)
+	for _, pattern := range allowed {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+			if strings.HasPrefix(suffix, ".") && strings.HasSuffix(host, suffix) && len(host) > len(suffix) {
+				return true
+			}
+			continue
+		}
+		if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// validateAllowedDomains checks that every path targets an allowed domain, reporting all
+// disallowed entries at once. An empty allowlist permits every domain.
+func validateAllowedDomains(paths, allowed []string) error {
+	if len(allowed) == 0 {
+		return nil
+	}
+	var disallowed []error
+	for _, path := range paths {
+		parsed, err := url.Parse(path)
+		if err != nil || !domainAllowed(parsed.Hostname(), allowed) {
+			disallowed = append(disallowed, newValueError(path, "path %q is outside allowed_domains (%s)", displayPath(path), strings.Join(allowed, ", ")))
+		}
+	}
+	return errors.Join(disallowed...)
+}
+
+// normalizeConfigPaths normalizes the purge paths so equivalent entries do not waste quota,
+// optionally prepending https:// to paths without a scheme, and drops duplicate paths when
+// purge_config.dedupe is set. Per-path flush types follow their paths.
+func normalizeConfigPaths(config *Config, autoScheme bool) {
+	lowerHost := config.PurgeConfig.LowercaseHost
+	config.PurgeConfig.Paths = normalizePaths(config.PurgeConfig.Paths, lowerHost)
+	config.PurgeConfig.FlushTypes = rekeyFlushTypes(config.PurgeConfig.FlushTypes, func(path string) string {
+		return normalizePath(path, lowerHost)
+	})
+	if autoScheme {
+		addDefaultScheme(config.PurgeConfig.Paths)
+		config.PurgeConfig.FlushTypes = rekeyFlushTypes(config.PurgeConfig.FlushTypes, withDefaultScheme)
+	}
+	if len(config.PurgeConfig.QueryVariants) > 0 && config.PurgeConfig.PurgeMode == purgeModeURL && !config.PurgeConfig.IgnoreQuery {
+		before := len(config.PurgeConfig.Paths)
+		config.PurgeConfig.Paths = expandQueryVariants(config.PurgeConfig.Paths, config.PurgeConfig.QueryVariants)
+		logger.Info("expanded query variants", "variants", len(config.PurgeConfig.QueryVariants), "paths", before, "urls", len(config.PurgeConfig.Paths))
+	}
+	if config.PurgeConfig.UrlEncode == urlEncodeAuto {
+		encodeConfigPaths(config)
+	}
+	if config.PurgeConfig.Dedupe {
+		var removed int
+		config.PurgeConfig.Paths, removed = dedupePaths(config.PurgeConfig.Paths)
+		logger.Info("removed duplicate paths", "duplicates", removed)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Prechecks are sent to the origin through the CDN rather than to the API, so they are
+// bounded by limits of their own
+const (
+	precheckTimeout           = 5 * time.Second
+	precheckConcurrency       = 8
+	precheckRequestsPerSecond = 20
+)
+
+// precheckResult is the outcome of the HEAD request to one URL, a status of 0 meaning the
+// request itself failed
+type precheckResult struct {
+	url    string
+	status int
+	err    error
+}
+
+// served reports whether the URL answered with a 2xx or 3xx status
+func (r precheckResult) served() bool {
+	return r.err == nil && r.status >= 200 && r.status < 400
+}
+
+// precheckURLs sends a HEAD request to every URL, at most precheckConcurrency at a time
+// and precheckRequestsPerSecond per second, and returns the URLs that are not served in
+// their original order. Redirects are not followed, a 3xx already shows the URL is served.
+func precheckURLs(ctx context.Context, client *http.Client, urls []string) []precheckResult {
+	results := make([]precheckResult, len(urls))
+	ticker := time.NewTicker(time.Second / precheckRequestsPerSecond)
+	defer ticker.Stop()
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for range min(precheckConcurrency, len(urls)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = headURL(ctx, client, urls[i])
+			}
+		}()
+	}
+	for i := range urls {
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+		}
+		if ctx.Err() != nil {
+			results[i] = precheckResult{url: urls[i], err: ctx.Err()}
+			continue
+		}
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	var failed []precheckResult
+	for _, result := range results {
+		if !result.served() {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// headURL sends one precheck request
+func headURL(ctx context.Context, client *http.Client, url string) precheckResult {
+	request, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return precheckResult{url: url, err: err}
+	}
+	request.Header.Set("User-Agent", userAgent())
+	response, err := client.Do(request)
+	if err != nil {
+		return precheckResult{url: url, err: err}
+	}
+	response.Body.Close()
+	return precheckResult{url: url, status: response.StatusCode}
+}
+
+// newPrecheckClient returns the HTTP client of the prechecks, which does not follow redirects
+func newPrecheckClient() *http.Client {
+	return &http.Client{
+		Timeout: precheckTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// runPrecheck prechecks the URLs of a precheck_urls run and reports those not served,
+// returning errPrecheckFailed when precheck_fail_on_error is set and any is found
+func runPrecheck(ctx context.Context, config *Config) error {
+	urls := config.PurgeConfig.Paths
+	failed := precheckURLs(ctx, newPrecheckClient(), urls)
+	logger.Info("prechecked URLs", "urls", len(urls), "not_served", len(failed))
+	if len(failed) == 0 {
+		out.Printf("Precheck: all %s are served\n", countOf(len(urls), "URL"))
+		return nil
+	}
+
+	out.Warnf("Precheck: %d of %s are not served:\n", len(failed), countOf(len(urls), "URL"))
+	for _, result := range failed {
+		if result.err != nil {
+			out.Warnf("  %s: %v\n", displayPath(result.url), result.err)
+		} else {
+			out.Warnf("  %s: %d %s\n", displayPath(result.url), result.status, http.StatusText(result.status))
+		}
+	}
+	if config.PurgeConfig.PrecheckFailOnError {
+		return fmt.Errorf("%w: %d of %d", errPrecheckFailed, len(failed), len(urls))
+	}
+	return nil
+}
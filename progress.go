@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// progress reports how far a long operation has come on stderr, rewriting a single line
+// when stderr is a terminal and printing one line per update otherwise. It is safe for
+// concurrent use.
+type progress struct {
+	mu sync.Mutex
+	// format receives the done and total counts, e.g. "batch %d/%d submitted"
+	format  string
+	enabled bool
+	inPlace bool
+	// pending is set while an in-place line awaits its final newline
+	pending bool
+}
+
+// Progress starts a progress display, disabled in JSON and quiet mode
+func (p *printer) Progress(format string) *progress {
+	return &progress{
+		format:  format,
+		enabled: p.format == outputText && !p.quiet,
+		inPlace: p.progressInPlace,
+	}
+}
+
+// Update shows that done of total steps have finished
+func (pr *progress) Update(done, total int) {
+	if !pr.enabled {
+		return
+	}
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	line := fmt.Sprintf(pr.format, done, total)
+	if pr.inPlace {
+		// Return to the line start and clear it before rewriting
+		fmt.Fprintf(os.Stderr, "\r\033[K%s", line)
+		pr.pending = true
+		return
+	}
+	fmt.Fprintln(os.Stderr, line)
+}
+
+// Done ends the in-place line so that later output starts on a fresh line
+func (pr *progress) Done() {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	if pr.pending {
+		fmt.Fprintln(os.Stderr)
+		pr.pending = false
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	cdn "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/cdn/v20180606"
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+
+	"git.ghink.net/ghink/PurgeCOSPathCache/purge"
+)
+
+// newPurgeConfig maps purge_config onto the purge of one account
+func newPurgeConfig(config *Config) purge.Config {
+	return purge.Config{
+		Mode:          config.PurgeConfig.PurgeMode,
+		Paths:         config.PurgeConfig.Paths,
+		FlushType:     config.PurgeConfig.FlushType,
+		FlushTypes:    config.PurgeConfig.FlushTypes,
+		URLEncode:     config.PurgeConfig.UrlEncode == urlEncodeOn,
+		Area:          config.PurgeConfig.Area,
+		BatchStrategy: config.PurgeConfig.BatchStrategy,
+		BatchSize:     batchSize(config),
+		Concurrency:   config.PurgeConfig.Concurrency,
+	}
+}
+
+// batchSize returns the batch size of the configured purge mode, since Tencent limits
+// directory and URL purges separately
+func batchSize(config *Config) int {
+	size := config.PurgeConfig.PathBatchSize
+	if config.PurgeConfig.PurgeMode == purgeModeURL {
+		size = config.PurgeConfig.URLBatchSize
+	}
+	if size == 0 {
+		return config.PurgeConfig.BatchSize
+	}
+	return size
+}
+
+// newPushRequest builds the prefetch request for the configured push URLs
+func newPushRequest(config *Config) *cdn.PushUrlsCacheRequest {
+	request := cdn.NewPushUrlsCacheRequest()
+	request.Urls = common.StringPtrs(config.PushConfig.Urls)
+
+	// Optional push parameters are only sent when specified in config
+	if config.PushConfig.Area != "" {
+		request.Area = common.StringPtr(config.PushConfig.Area)
+	}
+	if config.PushConfig.UserAgent != "" {
+		request.UserAgent = common.StringPtr(config.PushConfig.UserAgent)
+	}
+	if config.PushConfig.Layer != "" {
+		request.Layer = common.StringPtr(config.PushConfig.Layer)
+	}
+	switch config.PushConfig.UrlEncode {
+	case urlEncodeOn:
+		request.UrlEncode = common.BoolPtr(true)
+	case urlEncodeAuto:
+		urls := make([]string, len(config.PushConfig.Urls))
+		for i, url := range config.PushConfig.Urls {
+			urls[i] = encodePath(url)
+		}
+		request.Urls = common.StringPtrs(urls)
+	}
+	return request
+}
+
+// pushUrlsCache prefetches the configured URLs into the CDN edge cache,
+// retrying transient API errors
+func pushUrlsCache(ctx context.Context, acct *account, config *Config) (*cdn.PushUrlsCacheResponseParams, error) {
+	request := newPushRequest(config)
+	logger.Debug("submitting push request", "profile", acct.profile.Name, "payload", request.ToJsonString())
+	var response *cdn.PushUrlsCacheResponse
+	err := acct.call(ctx, "PushUrlsCache", func() (err error) {
+		response, err = acct.client.PushUrlsCacheWithContext(ctx, request)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("received push response", "profile", acct.profile.Name, "response", response.ToJsonString())
+	// A response without its body is treated as reporting no task
+	if response.Response == nil {
+		return &cdn.PushUrlsCacheResponseParams{}, nil
+	}
+	return response.Response, nil
+}
+
+// runOptions carries the command line switches that shape a purge run
+type runOptions struct {
+	push       bool
+	wait       bool
+	checkQuota bool
+	// checkDomains verifies that every path host is a domain of the account before purging
+	checkDomains bool
+	// summaryOnly leaves the per-profile lines out of the text output and verbose adds
+	// one line per batch
+	summaryOnly bool
+	verbose     bool
+	// failFast stops submitting batches after the first rejected one
+	failFast bool
+	// retryOnPartial resubmits the rejected batches once
+	retryOnPartial bool
+	// replay holds the paths a -replay resubmits to each area with this profile, nil when
+	// the configured paths are purged
+	replay map[string][]string
+}
+
+// runAccount purges the configured paths with one account, one purge per area, then
+// optionally waits for completion and prefetches the push URLs. The summary records
+// whatever was submitted even when an error is returned.
+func runAccount(ctx context.Context, acct *account, config *Config, opts runOptions) (*accountSummary, error) {
+	summary := newAccountSummary(acct.profile)
+
+	// Catch a purge submitted with the wrong account before any quota is spent
+	if opts.checkDomains {
+		if err := checkPathDomains(ctx, acct, config.PurgeConfig.Paths); err != nil {
+			return summary, fmt.Errorf("domain check failed: %w", err)
+		}
+	}
+
+	// Every area is purged even if an earlier one fails, so one partition failing does not
+	// leave the others stale
+	areas := config.PurgeConfig.Areas.targets()
+	var problems []error
+	var purged [][]string
+	replayed := len(areas)
+	for _, area := range areas {
+		if ctx.Err() != nil || (opts.failFast && len(problems) > 0) {
+			break
+		}
+		areaConfig := areaConfig(config, area)
+		if opts.replay != nil {
+			// A replay only resubmits the batches that failed in this area
+			areaConfig = replayConfig(areaConfig, opts.replay[area])
+			if len(areaConfig.PurgeConfig.Paths) == 0 {
+				replayed--
+				continue
+			}
+		}
+		if len(areas) > 1 && !opts.summaryOnly {
+			out.Printf("Area %s:\n", area)
+		}
+		result, err := purgeArea(ctx, acct, areaConfig, opts, summary)
+		if len(areas) > 1 {
+			areaResult := areaSummary{Area: area, TaskIDs: result.TaskIDs, BatchCount: result.BatchCount, FailedBatchCount: result.FailedCount}
+			if areaResult.TaskIDs == nil {
+				areaResult.TaskIDs = []string{}
+			}
+			if err != nil {
+				err = fmt.Errorf("area %s: %w", area, err)
+				areaResult.Error = err.Error()
+			}
+			summary.Areas = append(summary.Areas, areaResult)
+		}
+		if err != nil {
+			problems = append(problems, err)
+		}
+		purged = append(purged, result.PurgedPaths())
+		summary.QuotaUsed += len(result.PurgedPaths())
+	}
+	// A path only counts as purged once every area purged it
+	summary.purgedPaths = commonPaths(purged, replayed)
+	if len(problems) == 1 {
+		return summary, problems[0]
+	}
+	if err := errors.Join(problems...); err != nil {
+		return summary, err
+	}
+	if !opts.summaryOnly {
+		out.Successf("Purge operation completed successfully\n")
+	}
+
+	// Block until the purge has actually been applied on the edge nodes
+	if opts.wait {
+		timeout := time.Duration(config.PurgeConfig.WaitTimeout) * time.Second
+		interval := time.Duration(config.PurgeConfig.PollInterval) * time.Second
+		if err := waitForPurgeTasks(ctx, acct, summary.TaskIDs, timeout, interval); err != nil {
+			return summary, fmt.Errorf("waiting for purge tasks failed: %w", err)
+		}
+		out.Successf("Purge tasks completed: %s\n", strings.Join(summary.TaskIDs, ", "))
+	}
+
+	// Warm the edge cache only once the purge has succeeded
+	if opts.push {
+		pushResponse, err := pushUrlsCache(ctx, acct, config)
+		if err != nil {
+			return summary, fmt.Errorf("push failed: %s: %w", purge.DescribeError(err), err)
+		}
+		summary.PushTaskID = stringValue(pushResponse.TaskId)
+		out.Successf("Push operation completed successfully, task id: %s\n", summary.PushTaskID)
+		if requestID := stringValue(pushResponse.RequestId); requestID != "" {
+			summary.RequestIDs = append(summary.RequestIDs, requestID)
+			out.Printf("Push request id: %s\n", requestID)
+		}
+	}
+	return summary, nil
+}
+
+// purgeArea submits the purge of config, whose area is set, adding its task and request
+// ids and its batches to summary
+func purgeArea(ctx context.Context, acct *account, config *Config, opts runOptions, summary *accountSummary) (purge.Result, error) {
+	// Abort early rather than being rejected mid-deploy when quota runs out
+	if opts.checkQuota {
+		if err := checkPurgeQuota(ctx, acct, config); err != nil {
+			err = fmt.Errorf("quota check failed: %w", err)
+			// Nothing was submitted, so every batch of the area failed
+			for i, batch := range purge.Batches(newPurgeConfig(config)) {
+				summary.Batches = append(summary.Batches, batchSummary{Batch: i + 1, Area: config.PurgeConfig.Area, Paths: batch, Error: err.Error()})
+			}
+			return purge.Result{}, err
+		}
+	}
+
+	// Submit every batch even if an earlier one fails, so no paths are silently dropped
+	purgeProgress := out.Progress("batch %d/%d submitted")
+	purgeConfig := newPurgeConfig(config)
+	purgeConfig.Progress = purgeProgress.Update
+	purgeConfig.FailFast = opts.failFast
+	result, err := acct.purger.Purge(ctx, purgeConfig)
+	purgeProgress.Done()
+	if result.ResizedCount > 0 {
+		logger.Warn("batches exceeded the API path limit and were resubmitted in halves, lower batch_size, or path_batch_size and url_batch_size, to the batch limit reported by the quota command",
+			"profile", acct.profile.Name, "resized", result.ResizedCount, "batch_size", batchSize(config))
+	}
+	if err != nil && config.PurgeConfig.WaitForQuota {
+		err = resubmitAfterQuota(ctx, acct, config, &result, err)
+	}
+	if err != nil && opts.retryOnPartial && ctx.Err() == nil {
+		logger.Warn("resubmitting the failed batches once", "profile", acct.profile.Name, "batches", result.FailedCount)
+		retryConfig := newPurgeConfig(config)
+		retryConfig.FailFast = opts.failFast
+		err = acct.purger.Resubmit(ctx, retryConfig, &result, func(purge.BatchResult) bool { return true })
+	}
+	summary.TaskIDs = append(summary.TaskIDs, result.TaskIDs...)
+	summary.RequestIDs = append(summary.RequestIDs, result.RequestIDs...)
+	summary.BatchCount += result.BatchCount
+	summary.FailedBatchCount += result.FailedCount
+
+	// A partial failure always gets the per-batch report, so reruns know what to resubmit
+	if opts.verbose || result.FailedCount > 0 {
+		for i, batch := range result.Batches {
+			batchResult := newBatchSummary(i, batch)
+			batchResult.Area = config.PurgeConfig.Area
+			summary.Batches = append(summary.Batches, batchResult)
+		}
+		if !opts.summaryOnly {
+			printBatchReport(result)
+		}
+	}
+	if !opts.summaryOnly {
+		out.Printf("Purge submitted %d batches, task ids: %s\n", result.BatchCount, strings.Join(result.TaskIDs, ", "))
+		if len(result.RequestIDs) > 0 {
+			out.Printf("Request ids: %s\n", strings.Join(result.RequestIDs, ", "))
+		}
+	}
+	return result, err
+}
+
+// printBatchReport prints the outcome of every batch of result, with the first paths of each
+// failed batch
+func printBatchReport(result purge.Result) {
+	out.Printf("Batch report: %d of %d batches succeeded, %d failed\n",
+		result.BatchCount-result.FailedCount, result.BatchCount, result.FailedCount)
+	for i, batch := range result.Batches {
+		if batch.Err == nil {
+			out.Successf("  Batch %d/%d: %d paths, task id %s, request id %s\n", i+1, result.BatchCount, len(batch.Paths), batch.TaskID, batch.RequestID)
+			continue
+		}
+		if errors.Is(batch.Err, purge.ErrSkipped) {
+			out.Warnf("  Batch %d/%d: %d paths, skipped after an earlier failure\n", i+1, result.BatchCount, len(batch.Paths))
+		} else {
+			// API errors quote their request id in the description
+			out.Warnf("  Batch %d/%d: %d paths, failed: %s\n", i+1, result.BatchCount, len(batch.Paths), purge.DescribeError(batch.Err))
+		}
+		for _, path := range batch.Paths[:min(len(batch.Paths), maxWarningExamples)] {
+			out.Warnf("    %s\n", displayPath(path))
+		}
+		if len(batch.Paths) > maxWarningExamples {
+			out.Warnf("    and %d more\n", len(batch.Paths)-maxWarningExamples)
+		}
+	}
+}
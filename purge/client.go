@@ -0,0 +1,160 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	cdn "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/cdn/v20180606"
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/profile"
+)
+
+// DefaultEndpoint is the CDN API host used when Options.Endpoint is empty
+const DefaultEndpoint = "cdn.tencentcloudapi.com"
+
+// DefaultRequestsPerSecond stays well under Tencent's documented purge API limit of
+// 20 requests per second
+const DefaultRequestsPerSecond = 5
+
+// Default retry settings of the command line tool
+const (
+	DefaultMaxRetries     = 3
+	DefaultRetryBaseDelay = time.Second
+)
+
+// Supported values of Options.Language, the language of API error messages
+const (
+	LanguageEnglish = "en-US"
+	LanguageChinese = "zh-CN"
+)
+
+// Options configures the account and API settings of a Client
+type Options struct {
+	// SecretID and SecretKey are the API credentials, Token the optional session token
+	// of STS temporary credentials
+	SecretID  string
+	SecretKey string
+	Token     string
+	Region    string
+	// SignRegion is the region requests are signed for and sent in X-TC-Region, for
+	// region-scoped endpoints. Empty uses Region, which also selects the endpoint.
+	SignRegion string
+	// Partition selects the default endpoint of the account's site, see PartitionEndpoint
+	Partition string
+	// Endpoint and Scheme (http or https) override the API host and protocol
+	Endpoint string
+	Scheme   string
+	// Proxy routes API calls through this proxy URL, overriding HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+	Proxy string
+	// UserAgent is sent with every API request, empty keeps Go's default
+	UserAgent string
+	// Language selects the language of API error messages, LanguageEnglish or
+	// LanguageChinese. Empty keeps the SDK default.
+	Language string
+	// HTTP tunes connection reuse and the HTTP version of the API calls
+	HTTP TransportOptions
+	// Timeout bounds every HTTP request made to the API, 0 keeps the SDK default
+	Timeout time.Duration
+	// MaxRetries and RetryBaseDelay control retries of transient API errors, 0 disables retries
+	MaxRetries     int
+	RetryBaseDelay time.Duration
+	// RetryableCodes are the SDK error codes retried, matching sub-codes too. Nil selects
+	// DefaultRetryableCodes, an empty list retries nothing.
+	RetryableCodes []string
+	// RequestsPerSecond limits the rate of API calls, 0 selects DefaultRequestsPerSecond
+	RequestsPerSecond float64
+	// Logger receives progress and retry logs, nil discards them
+	Logger *slog.Logger
+}
+
+// Client submits purges with one account. It is safe for concurrent use.
+type Client struct {
+	opts    Options
+	cdn     *cdn.Client
+	limiter *rateLimiter
+	logger  *slog.Logger
+}
+
+// NewClient creates the CDN API client described by opts
+func NewClient(opts Options) (*Client, error) {
+	var credential *common.Credential
+	if opts.Token != "" {
+		credential = common.NewTokenCredential(opts.SecretID, opts.SecretKey, opts.Token)
+	} else {
+		credential = common.NewCredential(opts.SecretID, opts.SecretKey)
+	}
+
+	cpf := profile.NewClientProfile()
+	cpf.HttpProfile.Endpoint = opts.Endpoint
+	if opts.Endpoint == "" {
+		endpoint, err := PartitionEndpoint(opts.Partition, opts.Region)
+		if err != nil {
+			return nil, err
+		}
+		cpf.HttpProfile.Endpoint = endpoint
+	}
+	if opts.Language != "" {
+		if opts.Language != LanguageEnglish && opts.Language != LanguageChinese {
+			return nil, fmt.Errorf("unsupported language %q, expected %s or %s", opts.Language, LanguageEnglish, LanguageChinese)
+		}
+		cpf.Language = opts.Language
+	}
+	if opts.Scheme != "" {
+		cpf.HttpProfile.Scheme = strings.ToUpper(opts.Scheme)
+	}
+	if opts.Timeout > 0 {
+		cpf.HttpProfile.ReqTimeout = int((opts.Timeout + time.Second - 1) / time.Second)
+	}
+
+	signRegion := opts.Region
+	if opts.SignRegion != "" {
+		signRegion = opts.SignRegion
+	}
+	client, err := cdn.NewClient(credential, signRegion, cpf)
+	if err != nil {
+		return nil, err
+	}
+	transport, err := newTransport(opts)
+	if err != nil {
+		return nil, err
+	}
+	client.WithHttpTransport(transport)
+
+	rate := opts.RequestsPerSecond
+	if rate == 0 {
+		rate = DefaultRequestsPerSecond
+	}
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	return &Client{opts: opts, cdn: client, limiter: newRateLimiter(rate), logger: logger}, nil
+}
+
+// CDN returns the underlying SDK client, for API calls this package does not wrap.
+// Such calls should go through Call to honor the rate limit and retry policy.
+func (c *Client) CDN() *cdn.Client {
+	return c.cdn
+}
+
+// Call runs one API call under the client's rate limit, retrying transient errors.
+// Every attempt waits for the shared limiter, so concurrent callers stay within the limit.
+// fn should pass ctx to the SDK so that cancellation aborts the request in flight.
+func (c *Client) Call(ctx context.Context, fn func() error) error {
+	err := c.withRetry(ctx, func() error {
+		if err := c.limiter.Wait(ctx); err != nil {
+			return err
+		}
+		return fn()
+	})
+	// The SDK reports an aborted request as a network error, keep the cancellation visible
+	if err != nil && ctx.Err() != nil && !errors.Is(err, ctx.Err()) {
+		err = fmt.Errorf("%w: %v", ctx.Err(), err)
+	}
+	return err
+}
@@ -0,0 +1,24 @@
+// Package purge submits Tencent Cloud CDN cache purges, the core of the PurgeCOSPathCache
+// command line tool, for use from other Go programs.
+//
+// A Client wraps the CDN API client of one account and applies the account's rate limit
+// and retry policy to every call. Client.Purge splits the paths of a Config into batches
+// the API accepts, submits them and reports the task and request ids:
+//
+//	client, err := purge.NewClient(purge.Options{
+//		SecretID:   os.Getenv("TENCENTCLOUD_SECRET_ID"),
+//		SecretKey:  os.Getenv("TENCENTCLOUD_SECRET_KEY"),
+//		MaxRetries: purge.DefaultMaxRetries,
+//	})
+//	if err != nil {
+//		return err
+//	}
+//	result, err := client.Purge(ctx, purge.Config{
+//		Mode:      purge.ModePath,
+//		Paths:     []string{"https://example.com/css/"},
+//		FlushType: purge.FlushTypeFlush,
+//	})
+//
+// The exported identifiers of this package form a stable API: fields and options may be
+// added, but existing ones keep their meaning.
+package purge
@@ -0,0 +1,42 @@
+package purge
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Supported values of Options.Partition, the Tencent Cloud site an account belongs to
+const (
+	// PartitionCN is the mainland China site, cloud.tencent.com
+	PartitionCN = "cn"
+	// PartitionIntl is the international site, tencentcloud.com
+	PartitionIntl = "intl"
+	// PartitionFinance is the finance cloud, whose regions end in -fsi
+	PartitionFinance = "finance"
+)
+
+// financeRegionSuffix marks the regions of the finance cloud, such as ap-shanghai-fsi
+const financeRegionSuffix = "-fsi"
+
+// PartitionEndpoint returns the default CDN API host of a partition, empty selecting
+// PartitionCN. The finance cloud serves the API from per-region hosts, so it needs region.
+func PartitionEndpoint(partition, region string) (string, error) {
+	switch partition {
+	case "", PartitionCN:
+		return DefaultEndpoint, nil
+	case PartitionIntl:
+		return "cdn.intl.tencentcloudapi.com", nil
+	case PartitionFinance:
+		if !IsFinanceRegion(region) {
+			return "", fmt.Errorf("partition %s requires a finance cloud region such as ap-shanghai-fsi, got %q", partition, region)
+		}
+		return "cdn." + region + ".tencentcloudapi.com", nil
+	default:
+		return "", fmt.Errorf("unsupported partition %q, expected %s, %s or %s", partition, PartitionCN, PartitionIntl, PartitionFinance)
+	}
+}
+
+// IsFinanceRegion reports whether region belongs to the finance cloud
+func IsFinanceRegion(region string) bool {
+	return strings.HasSuffix(region, financeRegionSuffix)
+}
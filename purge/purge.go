@@ -0,0 +1,531 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	cdn "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/cdn/v20180606"
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	tencentCloudSDKErrors "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Supported values of Config.Mode
+const (
+	// ModePath purges directories with PurgePathCache
+	ModePath = "path"
+	// ModeURL purges individual URLs with PurgeUrlsCache
+	ModeURL = "url"
+)
+
+// Supported flush types of directory purges
+const (
+	// FlushTypeFlush purges the changed resources under a directory
+	FlushTypeFlush = "flush"
+	// FlushTypeDelete purges every resource under a directory
+	FlushTypeDelete = "delete"
+)
+
+// MaxBatchSize is the number of paths Tencent accepts in a single purge call
+const MaxBatchSize = 1000
+
+// BatchByCount is the batch strategy capping the number of paths of every batch at BatchSize
+const BatchByCount = "count"
+
+// Config describes one purge
+type Config struct {
+	// Mode is ModePath or ModeURL, empty selects ModePath
+	Mode string
+	// Paths are the directories or URLs to purge, each with an http:// or https:// header
+	Paths []string
+	// FlushType applies to the directory purges of every path without an entry in FlushTypes
+	FlushType string
+	// FlushTypes overrides FlushType for individual paths
+	FlushTypes map[string]string
+	// URLEncode asks the API to percent-encode the paths
+	URLEncode bool
+	// Area is mainland, overseas or global, empty purges mainland nodes only
+	Area string
+	// BatchStrategy decides where the paths are split into batches, empty selects
+	// BatchByCount, the only strategy so far
+	BatchStrategy string
+	// BatchSize caps the number of paths per API call, 0 selects MaxBatchSize
+	BatchSize int
+	// Concurrency is the number of batches submitted in parallel, 0 submits them one at a time
+	Concurrency int
+	// Progress, when set, is called after each batch with the number of batches finished
+	// so far, successfully or not, out of total. Calls are serialized.
+	Progress func(done, total int)
+	// FailFast stops submitting batches once one is rejected. The batches not submitted
+	// yet fail with ErrSkipped, while the ones already in flight still complete.
+	FailFast bool
+}
+
+// ErrSkipped is the error of the batches FailFast left unsubmitted after an earlier failure
+var ErrSkipped = errors.New("not submitted after an earlier batch failed")
+
+// BatchResult is the outcome of one submitted batch
+type BatchResult struct {
+	Paths  []string
+	TaskID string
+	// RequestID identifies the API call, also when it was rejected
+	RequestID string
+	// Err is set when the batch was rejected or could not be submitted
+	Err error
+}
+
+// Result is the outcome of a purge. Purge fills it in even when it returns an error,
+// so the batches already submitted are never lost.
+type Result struct {
+	// TaskIDs and RequestIDs list the ids of the successful batches, in batch order
+	TaskIDs    []string
+	RequestIDs []string
+	PathCount  int
+	BatchCount int
+	// FailedCount is the number of batches that were rejected
+	FailedCount int
+	// ResizedCount is the number of batches rejected for exceeding the API path limit,
+	// which Purge split in two and submitted again
+	ResizedCount int
+	// Batches holds the outcome of every batch, in batch order
+	Batches []BatchResult
+}
+
+// PurgedPaths returns the paths of the successful batches
+func (r *Result) PurgedPaths() []string {
+	var paths []string
+	for _, batch := range r.Batches {
+		if batch.Err == nil {
+			paths = append(paths, batch.Paths...)
+		}
+	}
+	return paths
+}
+
+// withDefaults fills in the optional settings left unset
+func (cfg Config) withDefaults() Config {
+	if cfg.Mode == "" {
+		cfg.Mode = ModePath
+	}
+	if cfg.BatchStrategy == "" {
+		cfg.BatchStrategy = BatchByCount
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = MaxBatchSize
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 1
+	}
+	return cfg
+}
+
+// flushType returns the flush type of path, its own when set in FlushTypes or FlushType otherwise
+func (cfg Config) flushType(path string) string {
+	if flushType, ok := cfg.FlushTypes[path]; ok {
+		return flushType
+	}
+	return cfg.FlushType
+}
+
+// batchStrategy decides how many of the leading paths go into the next batch
+type batchStrategy interface {
+	next(paths []string) int
+}
+
+// countStrategy fills every batch with up to its number of paths
+type countStrategy int
+
+func (size countStrategy) next(paths []string) int {
+	return min(int(size), len(paths))
+}
+
+// strategy returns the batchStrategy selected by BatchStrategy, nil when it is unknown
+func (cfg Config) strategy() batchStrategy {
+	switch cfg.BatchStrategy {
+	case BatchByCount:
+		return countStrategy(cfg.BatchSize)
+	default:
+		return nil
+	}
+}
+
+// chunk splits paths into consecutive batches as decided by strategy, each holding at
+// least one path
+func chunk(paths []string, strategy batchStrategy) [][]string {
+	var batches [][]string
+	for len(paths) > 0 {
+		n := max(strategy.next(paths), 1)
+		batches = append(batches, paths[:n])
+		paths = paths[n:]
+	}
+	return batches
+}
+
+// Batches groups the paths by flush type, in order of first appearance, and splits each
+// group into batches as decided by BatchStrategy, so every batch maps onto one request.
+// An unknown BatchStrategy batches by count, Purge rejects it.
+func Batches(cfg Config) [][]string {
+	cfg = cfg.withDefaults()
+	strategy := cfg.strategy()
+	if strategy == nil {
+		strategy = countStrategy(cfg.BatchSize)
+	}
+	var flushTypes []string
+	groups := make(map[string][]string)
+	for _, path := range cfg.Paths {
+		flushType := cfg.flushType(path)
+		if _, ok := groups[flushType]; !ok {
+			flushTypes = append(flushTypes, flushType)
+		}
+		groups[flushType] = append(groups[flushType], path)
+	}
+
+	var batches [][]string
+	for _, flushType := range flushTypes {
+		batches = append(batches, chunk(groups[flushType], strategy)...)
+	}
+	return batches
+}
+
+// newPathCacheRequest builds a directory cache purge request for the given paths
+func newPathCacheRequest(cfg Config, paths []string) *cdn.PurgePathCacheRequest {
+	request := cdn.NewPurgePathCacheRequest()
+
+	// Paths must include protocol header (http:// or https://)
+	request.Paths = common.StringPtrs(paths)
+	// Batches never mixes flush types within a batch, so the first path decides for all
+	flushType := cfg.FlushType
+	if len(paths) > 0 {
+		flushType = cfg.flushType(paths[0])
+	}
+	request.FlushType = common.StringPtr(flushType)
+	request.UrlEncode = common.BoolPtr(cfg.URLEncode)
+
+	// Area parameter is optional, only set if specified
+	if cfg.Area != "" {
+		request.Area = common.StringPtr(cfg.Area)
+	}
+	return request
+}
+
+// newUrlsCacheRequest builds a URL cache purge request for the given paths
+func newUrlsCacheRequest(cfg Config, paths []string) *cdn.PurgeUrlsCacheRequest {
+	request := cdn.NewPurgeUrlsCacheRequest()
+
+	// URL purge shares the path list but takes no flush type
+	request.Urls = common.StringPtrs(paths)
+	request.UrlEncode = common.BoolPtr(cfg.URLEncode)
+
+	if cfg.Area != "" {
+		request.Area = common.StringPtr(cfg.Area)
+	}
+	return request
+}
+
+// RenderRequest returns the JSON body that would be submitted for one batch of paths
+func RenderRequest(cfg Config, paths []string) string {
+	if cfg.withDefaults().Mode == ModeURL {
+		return newUrlsCacheRequest(cfg, paths).ToJsonString()
+	}
+	return newPathCacheRequest(cfg, paths).ToJsonString()
+}
+
+// submit submits the batch of paths at index using the configured mode, retrying transient
+// API errors, within a span carrying the batch attributes
+func (c *Client) submit(ctx context.Context, cfg Config, index int, paths []string) (taskID, requestID string, err error) {
+	action := "PurgePathCache"
+	flushType := cfg.flushType(paths[0])
+	if cfg.Mode == ModeURL {
+		action, flushType = "PurgeUrlsCache", ""
+	}
+	ctx, span := tracer.Start(ctx, action, trace.WithAttributes(
+		attribute.Int("purge.batch_index", index),
+		attribute.Int("purge.path_count", len(paths)),
+		attribute.String("purge.mode", cfg.Mode),
+		attribute.String("purge.flush_type", flushType),
+		attribute.String("purge.area", cfg.Area)))
+	defer func() {
+		if taskID != "" {
+			span.SetAttributes(attribute.String("purge.task_id", taskID))
+		}
+		endSpan(span, requestID, err)
+	}()
+
+	c.logger.Debug("submitting purge request", "payload", RenderRequest(cfg, paths))
+	var response string
+	err = c.Call(ctx, func() error {
+		if cfg.Mode == ModeURL {
+			r, err := c.cdn.PurgeUrlsCacheWithContext(ctx, newUrlsCacheRequest(cfg, paths))
+			if err != nil {
+				return err
+			}
+			if r.Response != nil {
+				taskID, requestID = stringValue(r.Response.TaskId), stringValue(r.Response.RequestId)
+			}
+			response = r.ToJsonString()
+			return nil
+		}
+		r, err := c.cdn.PurgePathCacheWithContext(ctx, newPathCacheRequest(cfg, paths))
+		if err != nil {
+			return err
+		}
+		if r.Response != nil {
+			taskID, requestID = stringValue(r.Response.TaskId), stringValue(r.Response.RequestId)
+		}
+		response = r.ToJsonString()
+		return nil
+	})
+	if err != nil {
+		if ids := RequestIDs(err); len(ids) > 0 {
+			requestID = ids[0]
+		}
+		return "", requestID, err
+	}
+	c.logger.Debug("received purge response", "response", response)
+	// Without a task id the purge cannot be tracked, so the batch is not counted as submitted
+	if taskID == "" {
+		err = fmt.Errorf("%s response without a task id", action)
+		return "", requestID, err
+	}
+	return taskID, requestID, nil
+}
+
+// stringValue dereferences an optional response field, returning "" when it is absent
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// Purge submits the paths of cfg in batches with up to cfg.Concurrency requests in flight.
+// Unless cfg.FailFast is set, every batch is submitted even if an earlier one fails, so no
+// paths are silently dropped; the returned error then wraps a BatchErrors listing the
+// rejected batches. A batch rejected for exceeding the API path limit is split in two and
+// submitted again, once. Once ctx is done
+// no further batch is submitted and the remaining ones fail with ctx.Err().
+func (c *Client) Purge(ctx context.Context, cfg Config) (Result, error) {
+	cfg = cfg.withDefaults()
+	if cfg.strategy() == nil {
+		return Result{}, fmt.Errorf("unknown batch strategy %q", cfg.BatchStrategy)
+	}
+	batches := Batches(cfg)
+	result := Result{
+		PathCount:  len(cfg.Paths),
+		BatchCount: len(batches),
+		Batches:    make([]BatchResult, len(batches)),
+	}
+	pending := make([]int, len(batches))
+	for i := range batches {
+		result.Batches[i].Paths = batches[i]
+		pending[i] = i
+	}
+	c.submitBatches(ctx, cfg, &result, pending)
+	c.resizeOversized(ctx, cfg, &result)
+	return result, result.finish()
+}
+
+// resizeOversized splits every batch of result rejected for exceeding the API path limit
+// in two and submits the halves once, so a batch size above the limit of the account does
+// not fail the purge. The halves replace the batch in result.Batches.
+func (c *Client) resizeOversized(ctx context.Context, cfg Config, result *Result) {
+	var batches []BatchResult
+	var pending []int
+	for _, batch := range result.Batches {
+		if !IsBatchLimitError(batch.Err) || len(batch.Paths) < 2 {
+			batches = append(batches, batch)
+			continue
+		}
+		halves := chunk(batch.Paths, countStrategy((len(batch.Paths)+1)/2))
+		c.logger.Warn("batch exceeded the API path limit, resubmitting it in smaller batches",
+			"paths", len(batch.Paths), "batches", len(halves), "error", DescribeError(batch.Err))
+		for _, half := range halves {
+			pending = append(pending, len(batches))
+			batches = append(batches, BatchResult{Paths: half})
+		}
+		result.ResizedCount++
+	}
+	if len(pending) == 0 {
+		return
+	}
+	result.Batches = batches
+	result.BatchCount = len(batches)
+	c.submitBatches(ctx, cfg, result, pending)
+}
+
+// Resubmit submits again the rejected batches of result for which retry returns true,
+// updating result in place. It returns the error of the batches still rejected, as Purge does.
+// cfg must be the configuration result was submitted with.
+func (c *Client) Resubmit(ctx context.Context, cfg Config, result *Result, retry func(BatchResult) bool) error {
+	cfg = cfg.withDefaults()
+	var pending []int
+	for i, batch := range result.Batches {
+		if batch.Err != nil && retry(batch) {
+			pending = append(pending, i)
+		}
+	}
+	c.submitBatches(ctx, cfg, result, pending)
+	return result.finish()
+}
+
+// submitBatches submits the batches of result at the pending indices with up to
+// cfg.Concurrency requests in flight, recording the outcome of each in result.Batches
+func (c *Client) submitBatches(ctx context.Context, cfg Config, result *Result, pending []int) {
+	// Each result slot is written by a single worker
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	finished := 0
+	// failed is closed on the first rejected batch when cfg.FailFast is set
+	failed := make(chan struct{})
+	var failOnce sync.Once
+	report := func() {
+		if cfg.Progress == nil {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		finished++
+		cfg.Progress(finished, len(pending))
+	}
+	for w := 0; w < cfg.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				batch := &result.Batches[i]
+				batch.TaskID, batch.RequestID, batch.Err = c.submit(ctx, cfg, i, batch.Paths)
+				report()
+				if batch.Err != nil {
+					c.logger.Error("batch failed", "batch", i+1, "batches", result.BatchCount, "error", DescribeError(batch.Err))
+					if cfg.FailFast {
+						failOnce.Do(func() { close(failed) })
+					}
+					continue
+				}
+				c.logger.Info("batch submitted",
+					"batch", i+1,
+					"batches", result.BatchCount,
+					"paths", len(batch.Paths),
+					"task_id", batch.TaskID)
+			}
+		}()
+	}
+
+	next := 0
+	var stopErr error
+feed:
+	for ; next < len(pending); next++ {
+		select {
+		case <-failed:
+			stopErr = ErrSkipped
+			break feed
+		default:
+		}
+		select {
+		case jobs <- pending[next]:
+		case <-ctx.Done():
+			stopErr = ctx.Err()
+			break feed
+		case <-failed:
+			stopErr = ErrSkipped
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, i := range pending[next:] {
+		result.Batches[i] = BatchResult{Paths: result.Batches[i].Paths, Err: stopErr}
+	}
+}
+
+// finish recomputes the ids and failure count of result from its batches and returns
+// the error listing the rejected batches, nil when every batch succeeded
+func (r *Result) finish() error {
+	r.TaskIDs, r.RequestIDs = nil, nil
+	var failed BatchErrors
+	for i, batch := range r.Batches {
+		if batch.Err != nil {
+			failed = append(failed, &BatchError{Index: i, Total: len(r.Batches), Err: batch.Err})
+			continue
+		}
+		r.TaskIDs = append(r.TaskIDs, batch.TaskID)
+		r.RequestIDs = append(r.RequestIDs, batch.RequestID)
+	}
+	r.FailedCount = len(failed)
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d batches were rejected: %w", len(failed), len(r.Batches), failed)
+	}
+	return nil
+}
+
+// DescribeError formats an error returned by an API call for display
+func DescribeError(err error) string {
+	var tencentCloudSDKError *tencentCloudSDKErrors.TencentCloudSDKError
+	if errors.As(err, &tencentCloudSDKError) {
+		return fmt.Sprintf("API error returned: %s", err)
+	}
+	if errors.Is(err, ErrSkipped) {
+		return "Skipped: " + err.Error()
+	}
+	return fmt.Sprintf("Unexpected error: %v", err)
+}
+
+// RequestIDs returns the request ids carried by the API errors within err, including every
+// error of a multi-batch failure, so they can be quoted in support tickets
+func RequestIDs(err error) []string {
+	var ids []string
+	switch e := err.(type) {
+	case nil:
+	case interface{ Unwrap() []error }:
+		for _, inner := range e.Unwrap() {
+			ids = append(ids, RequestIDs(inner)...)
+		}
+	case interface{ Unwrap() error }:
+		// A wrapper such as the error of finish may hold several batch errors, which
+		// errors.As would stop at the first of
+		ids = RequestIDs(e.Unwrap())
+	default:
+		var sdkErr *tencentCloudSDKErrors.TencentCloudSDKError
+		if errors.As(err, &sdkErr) && sdkErr.RequestId != "" {
+			ids = append(ids, sdkErr.RequestId)
+		}
+	}
+	return ids
+}
+
+// BatchError records why one batch of paths was rejected
+type BatchError struct {
+	// Index is the zero-based position of the batch among Total batches
+	Index int
+	Total int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("batch %d/%d failed: %s", e.Index+1, e.Total, DescribeError(e.Err))
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// BatchErrors collects the failures of a multi-batch submission
+type BatchErrors []error
+
+func (e BatchErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (e BatchErrors) Unwrap() []error {
+	return e
+}
@@ -0,0 +1,575 @@
+package purge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tencentCloudSDKErrors "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/errors"
+)
+
+// mockRequest is one API call received by the mock CDN endpoint
+type mockRequest struct {
+	Action    string
+	UserAgent string
+	Language  string
+	Region    string
+	Body      map[string]interface{}
+}
+
+// mockCDN serves the CDN API over HTTP, answering every call with respond
+type mockCDN struct {
+	server *httptest.Server
+
+	mu       sync.Mutex
+	requests []mockRequest
+}
+
+// newMockCDN starts a mock endpoint whose respond returns the Response object of each call
+func newMockCDN(t testing.TB, respond func(request mockRequest) map[string]interface{}) *mockCDN {
+	t.Helper()
+	m := &mockCDN{}
+	m.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("reading request body: %v", err)
+		}
+		request := mockRequest{Action: r.Header.Get("X-TC-Action"), UserAgent: r.UserAgent(), Language: r.Header.Get("X-TC-Language"), Region: r.Header.Get("X-TC-Region")}
+		if err := json.Unmarshal(data, &request.Body); err != nil {
+			t.Errorf("decoding request body %q: %v", data, err)
+		}
+		m.mu.Lock()
+		m.requests = append(m.requests, request)
+		m.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"Response": respond(request)})
+	}))
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+// client returns a Client targeting the mock endpoint without retries
+func (m *mockCDN) client(t *testing.T) *Client {
+	t.Helper()
+	client, err := NewClient(Options{
+		SecretID:          "id",
+		SecretKey:         "key",
+		Endpoint:          strings.TrimPrefix(m.server.URL, "http://"),
+		Scheme:            "http",
+		RequestsPerSecond: 1000,
+	})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	return client
+}
+
+// taskResponse is a successful purge response with the given ids
+func taskResponse(taskID, requestID string) map[string]interface{} {
+	return map[string]interface{}{"TaskId": taskID, "RequestId": requestID}
+}
+
+// errorResponse is an API error response with the given code
+func errorResponse(code, requestID string) map[string]interface{} {
+	return map[string]interface{}{
+		"Error":     map[string]interface{}{"Code": code, "Message": "mock failure"},
+		"RequestId": requestID,
+	}
+}
+
+// stringList converts a decoded JSON array of strings
+func stringList(v interface{}) []string {
+	var list []string
+	items, _ := v.([]interface{})
+	for _, item := range items {
+		s, _ := item.(string)
+		list = append(list, s)
+	}
+	return list
+}
+
+func TestPurgeSubmitsBatchesByFlushType(t *testing.T) {
+	mock := newMockCDN(t, func(request mockRequest) map[string]interface{} {
+		return taskResponse("task-"+request.Body["FlushType"].(string), "req-1")
+	})
+
+	result, err := mock.client(t).Purge(context.Background(), Config{
+		Mode:       ModePath,
+		Paths:      []string{"https://example.com/a/", "https://example.com/b/", "https://example.com/c/"},
+		FlushType:  FlushTypeFlush,
+		FlushTypes: map[string]string{"https://example.com/b/": FlushTypeDelete},
+		Area:       "global",
+	})
+	if err != nil {
+		t.Fatalf("Purge: %v", err)
+	}
+
+	if len(mock.requests) != 2 {
+		t.Fatalf("got %d requests, want 2", len(mock.requests))
+	}
+	want := []struct {
+		flushType string
+		paths     []string
+	}{
+		{FlushTypeFlush, []string{"https://example.com/a/", "https://example.com/c/"}},
+		{FlushTypeDelete, []string{"https://example.com/b/"}},
+	}
+	for i, request := range mock.requests {
+		if request.Action != "PurgePathCache" {
+			t.Errorf("request %d action = %q, want PurgePathCache", i, request.Action)
+		}
+		if got := stringList(request.Body["Paths"]); !reflect.DeepEqual(got, want[i].paths) {
+			t.Errorf("request %d Paths = %q, want %q", i, got, want[i].paths)
+		}
+		if request.Body["FlushType"] != want[i].flushType {
+			t.Errorf("request %d FlushType = %v, want %q", i, request.Body["FlushType"], want[i].flushType)
+		}
+		if request.Body["Area"] != "global" {
+			t.Errorf("request %d Area = %v, want global", i, request.Body["Area"])
+		}
+	}
+
+	if !reflect.DeepEqual(result.TaskIDs, []string{"task-flush", "task-delete"}) {
+		t.Errorf("TaskIDs = %q", result.TaskIDs)
+	}
+	if result.PathCount != 3 || result.BatchCount != 2 || result.FailedCount != 0 {
+		t.Errorf("counts = %d paths, %d batches, %d failed", result.PathCount, result.BatchCount, result.FailedCount)
+	}
+}
+
+func TestClientSendsUserAgentAndLanguage(t *testing.T) {
+	mock := newMockCDN(t, func(mockRequest) map[string]interface{} {
+		return taskResponse("task-1", "req-1")
+	})
+	client, err := NewClient(Options{
+		SecretID:  "id",
+		SecretKey: "key",
+		Endpoint:  strings.TrimPrefix(mock.server.URL, "http://"),
+		Scheme:    "http",
+		UserAgent: "PurgeCOSPathCache/test",
+		Language:  LanguageEnglish,
+	})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	if _, err := client.Purge(context.Background(), Config{Paths: []string{"https://example.com/a/"}, FlushType: FlushTypeFlush}); err != nil {
+		t.Fatalf("Purge: %v", err)
+	}
+	if got := mock.requests[0].UserAgent; got != "PurgeCOSPathCache/test" {
+		t.Errorf("User-Agent = %q", got)
+	}
+	if got := mock.requests[0].Language; got != LanguageEnglish {
+		t.Errorf("X-TC-Language = %q, want %q", got, LanguageEnglish)
+	}
+}
+
+func TestClientSignsForSignRegion(t *testing.T) {
+	mock := newMockCDN(t, func(mockRequest) map[string]interface{} {
+		return taskResponse("task-1", "req-1")
+	})
+	client, err := NewClient(Options{
+		SecretID:   "id",
+		SecretKey:  "key",
+		Region:     "ap-guangzhou",
+		SignRegion: "ap-singapore",
+		Endpoint:   strings.TrimPrefix(mock.server.URL, "http://"),
+		Scheme:     "http",
+	})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	if _, err := client.Purge(context.Background(), Config{Paths: []string{"https://example.com/a/"}, FlushType: FlushTypeFlush}); err != nil {
+		t.Fatalf("Purge: %v", err)
+	}
+	if got := mock.requests[0].Region; got != "ap-singapore" {
+		t.Errorf("X-TC-Region = %q, want the sign region", got)
+	}
+}
+
+func TestPurgeURLModeOmitsFlushType(t *testing.T) {
+	mock := newMockCDN(t, func(mockRequest) map[string]interface{} {
+		return taskResponse("task-1", "req-1")
+	})
+
+	_, err := mock.client(t).Purge(context.Background(), Config{
+		Mode:      ModeURL,
+		Paths:     []string{"https://example.com/a.js", "https://example.com/b.js", "https://example.com/c.js"},
+		BatchSize: 2,
+		URLEncode: true,
+	})
+	if err != nil {
+		t.Fatalf("Purge: %v", err)
+	}
+
+	if len(mock.requests) != 2 {
+		t.Fatalf("got %d requests, want 2", len(mock.requests))
+	}
+	request := mock.requests[0]
+	if request.Action != "PurgeUrlsCache" {
+		t.Errorf("action = %q, want PurgeUrlsCache", request.Action)
+	}
+	if _, ok := request.Body["FlushType"]; ok {
+		t.Errorf("URL purge sent FlushType %v", request.Body["FlushType"])
+	}
+	if request.Body["UrlEncode"] != true {
+		t.Errorf("UrlEncode = %v, want true", request.Body["UrlEncode"])
+	}
+	if got := stringList(request.Body["Urls"]); len(got) != 2 {
+		t.Errorf("first batch Urls = %q, want 2 entries", got)
+	}
+}
+
+func TestPurgeReportsAPIErrors(t *testing.T) {
+	mock := newMockCDN(t, func(request mockRequest) map[string]interface{} {
+		if strings.Contains(strings.Join(stringList(request.Body["Paths"]), ","), "bad") {
+			return errorResponse("InvalidParameter", "req-err")
+		}
+		return taskResponse("task-1", "req-1")
+	})
+
+	result, err := mock.client(t).Purge(context.Background(), Config{
+		Paths:     []string{"https://example.com/bad/", "https://example.com/good/"},
+		FlushType: FlushTypeFlush,
+		BatchSize: 1,
+	})
+	if err == nil {
+		t.Fatal("Purge succeeded, want an error")
+	}
+
+	var sdkErr *tencentCloudSDKErrors.TencentCloudSDKError
+	if !errors.As(err, &sdkErr) || sdkErr.Code != "InvalidParameter" {
+		t.Errorf("error %v does not wrap the InvalidParameter SDK error", err)
+	}
+	if ids := RequestIDs(err); !reflect.DeepEqual(ids, []string{"req-err"}) {
+		t.Errorf("RequestIDs = %q, want [req-err]", ids)
+	}
+	// The successful batch is still reported
+	if !reflect.DeepEqual(result.TaskIDs, []string{"task-1"}) || result.FailedCount != 1 {
+		t.Errorf("TaskIDs = %q, FailedCount = %d", result.TaskIDs, result.FailedCount)
+	}
+	if result.Batches[0].Err == nil || result.Batches[1].Err != nil {
+		t.Errorf("batch errors = %v, %v", result.Batches[0].Err, result.Batches[1].Err)
+	}
+	if result.Batches[0].RequestID != "req-err" {
+		t.Errorf("failed batch RequestID = %q, want req-err", result.Batches[0].RequestID)
+	}
+}
+
+func TestRequestIDsListsEveryRejectedBatch(t *testing.T) {
+	mock := newMockCDN(t, func(request mockRequest) map[string]interface{} {
+		paths := strings.Join(stringList(request.Body["Paths"]), ",")
+		if strings.Contains(paths, "bad") {
+			return errorResponse("InvalidParameter", "req-"+strings.TrimSuffix(strings.TrimPrefix(paths, "https://example.com/"), "/"))
+		}
+		return taskResponse("task-1", "req-1")
+	})
+
+	_, err := mock.client(t).Purge(context.Background(), Config{
+		Paths:     []string{"https://example.com/bad1/", "https://example.com/good/", "https://example.com/bad2/"},
+		FlushType: FlushTypeFlush,
+		BatchSize: 1,
+	})
+	if err == nil {
+		t.Fatal("Purge succeeded, want an error")
+	}
+	if ids := RequestIDs(err); !reflect.DeepEqual(ids, []string{"req-bad1", "req-bad2"}) {
+		t.Errorf("RequestIDs = %q, want [req-bad1 req-bad2]", ids)
+	}
+}
+
+func TestPurgeRejectsResponsesWithoutTaskID(t *testing.T) {
+	mock := newMockCDN(t, func(request mockRequest) map[string]interface{} {
+		if strings.Contains(strings.Join(stringList(request.Body["Paths"]), ","), "empty") {
+			return map[string]interface{}{}
+		}
+		return map[string]interface{}{"RequestId": "req-1"}
+	})
+
+	result, err := mock.client(t).Purge(context.Background(), Config{
+		Paths:     []string{"https://example.com/empty/", "https://example.com/no-task/"},
+		FlushType: FlushTypeFlush,
+		BatchSize: 1,
+	})
+	if err == nil || !strings.Contains(err.Error(), "without a task id") {
+		t.Fatalf("Purge error = %v, want the responses without a task id reported", err)
+	}
+	if result.FailedCount != 2 || len(result.TaskIDs) != 0 {
+		t.Errorf("FailedCount = %d, TaskIDs = %q, want both batches failed", result.FailedCount, result.TaskIDs)
+	}
+	if result.Batches[1].RequestID != "req-1" {
+		t.Errorf("RequestID = %q, want the request id of the response", result.Batches[1].RequestID)
+	}
+}
+
+func TestPurgeFailFastSkipsRemainingBatches(t *testing.T) {
+	mock := newMockCDN(t, func(request mockRequest) map[string]interface{} {
+		if strings.Contains(strings.Join(stringList(request.Body["Paths"]), ","), "bad") {
+			return errorResponse("InvalidParameter", "req-err")
+		}
+		return taskResponse("task-1", "req-1")
+	})
+
+	result, err := mock.client(t).Purge(context.Background(), Config{
+		Paths:     []string{"https://example.com/good/", "https://example.com/bad/", "https://example.com/next/"},
+		FlushType: FlushTypeFlush,
+		BatchSize: 1,
+		FailFast:  true,
+	})
+	if err == nil {
+		t.Fatal("Purge succeeded, want an error")
+	}
+	if len(mock.requests) != 2 {
+		t.Errorf("sent %d requests, want 2", len(mock.requests))
+	}
+	if !errors.Is(result.Batches[2].Err, ErrSkipped) || result.FailedCount != 2 {
+		t.Errorf("last batch error = %v, FailedCount = %d, want ErrSkipped and 2", result.Batches[2].Err, result.FailedCount)
+	}
+}
+
+func TestPurgeResizesBatchesOverThePathLimit(t *testing.T) {
+	mock := newMockCDN(t, func(request mockRequest) map[string]interface{} {
+		if len(stringList(request.Body["Paths"])) > 2 {
+			return errorResponse("LimitExceeded.CdnPurgePathExceedBatchLimit", "req-limit")
+		}
+		return taskResponse("task-1", "req-1")
+	})
+
+	result, err := mock.client(t).Purge(context.Background(), Config{
+		Paths:     []string{"https://e.com/1/", "https://e.com/2/", "https://e.com/3/", "https://e.com/4/", "https://e.com/5/"},
+		FlushType: FlushTypeFlush,
+		BatchSize: 4,
+	})
+	if err != nil {
+		t.Fatalf("Purge: %v", err)
+	}
+	// The first batch of 4 is split into 2 batches of 2, the last batch of 1 is kept
+	if result.ResizedCount != 1 || result.BatchCount != 3 || len(result.TaskIDs) != 3 {
+		t.Errorf("ResizedCount = %d, BatchCount = %d, TaskIDs = %q, want 1, 3 and 3 task ids", result.ResizedCount, result.BatchCount, result.TaskIDs)
+	}
+	if got := result.Batches[1].Paths; !reflect.DeepEqual(got, []string{"https://e.com/3/", "https://e.com/4/"}) {
+		t.Errorf("second batch = %q, want the second half of the split batch", got)
+	}
+	if len(mock.requests) != 4 {
+		t.Errorf("sent %d requests, want 4", len(mock.requests))
+	}
+}
+
+func TestPurgeRetriesNetworkFailures(t *testing.T) {
+	// A closed server refuses connections, which the SDK reports as a network error
+	mock := newMockCDN(t, func(mockRequest) map[string]interface{} { return nil })
+	client := mock.client(t)
+	mock.server.Close()
+	client.opts.MaxRetries = 2
+	client.opts.RetryBaseDelay = time.Millisecond
+
+	attempts := 0
+	err := client.Call(context.Background(), func() error {
+		attempts++
+		_, err := client.CDN().PurgePathCacheWithContext(context.Background(), newPathCacheRequest(Config{FlushType: FlushTypeFlush}, []string{"https://example.com/"}))
+		return err
+	})
+
+	var sdkErr *tencentCloudSDKErrors.TencentCloudSDKError
+	if !errors.As(err, &sdkErr) || sdkErr.Code != "ClientError.NetworkError" {
+		t.Fatalf("error = %v, want ClientError.NetworkError", err)
+	}
+	if attempts != 3 {
+		t.Errorf("attempts = %d, want 3", attempts)
+	}
+}
+
+func TestPurgeRetriesConfiguredCodes(t *testing.T) {
+	tests := []struct {
+		name  string
+		code  string
+		codes []string
+		want  int
+	}{
+		{"defaults", "ResourceUnavailable", nil, 1},
+		{"configured code", "ResourceUnavailable", []string{"ResourceUnavailable"}, 3},
+		{"sub-code", "ResourceUnavailable.CdnHostBusy", []string{"ResourceUnavailable"}, 3},
+		{"replaced defaults", "InternalError", []string{}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := newMockCDN(t, func(mockRequest) map[string]interface{} {
+				return errorResponse(tt.code, "req-err")
+			})
+			client := mock.client(t)
+			client.opts.MaxRetries = 2
+			client.opts.RetryBaseDelay = time.Millisecond
+			client.opts.RetryableCodes = tt.codes
+
+			if _, err := client.Purge(context.Background(), Config{Paths: []string{"https://example.com/"}, FlushType: FlushTypeFlush}); err == nil {
+				t.Fatal("Purge succeeded, want an error")
+			}
+			if len(mock.requests) != tt.want {
+				t.Errorf("got %d attempts, want %d", len(mock.requests), tt.want)
+			}
+		})
+	}
+}
+
+func TestPurgeStopsWhenContextIsCancelled(t *testing.T) {
+	mock := newMockCDN(t, func(mockRequest) map[string]interface{} {
+		return taskResponse("task-1", "req-1")
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	result, err := mock.client(t).Purge(ctx, Config{
+		Paths:     []string{"https://example.com/a/", "https://example.com/b/"},
+		FlushType: FlushTypeFlush,
+		BatchSize: 1,
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("error = %v, want context.Canceled", err)
+	}
+	if len(result.TaskIDs) != 0 || result.FailedCount != 2 {
+		t.Errorf("TaskIDs = %q, FailedCount = %d", result.TaskIDs, result.FailedCount)
+	}
+}
+
+func TestBatches(t *testing.T) {
+	paths := []string{"https://e.com/1/", "https://e.com/2/", "https://e.com/3/"}
+	tests := []struct {
+		name string
+		cfg  Config
+		want [][]string
+	}{
+		{"empty", Config{}, nil},
+		{"one batch", Config{Paths: paths}, [][]string{paths}},
+		{"exact multiple", Config{Paths: paths[:2], BatchSize: 1}, [][]string{paths[:1], paths[1:2]}},
+		{"remainder", Config{Paths: paths, BatchSize: 2}, [][]string{paths[:2], paths[2:]}},
+		{
+			"grouped by flush type",
+			Config{Paths: paths, FlushType: FlushTypeFlush, FlushTypes: map[string]string{paths[0]: FlushTypeDelete}},
+			[][]string{paths[:1], paths[1:]},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Batches(tt.cfg); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Batches = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChunk(t *testing.T) {
+	paths := []string{"https://e.com/1/", "https://e.com/2/", "https://e.com/3/", "https://e.com/4/"}
+	tests := []struct {
+		name  string
+		paths []string
+		size  int
+		want  [][]string
+	}{
+		{"empty", nil, 2, nil},
+		{"under the limit", paths[:1], 2, [][]string{paths[:1]}},
+		{"exactly at the limit", paths[:2], 2, [][]string{paths[:2]}},
+		{"one over the limit", paths[:3], 2, [][]string{paths[:2], paths[2:3]}},
+		{"exact multiple", paths, 2, [][]string{paths[:2], paths[2:]}},
+		{"one per batch", paths[:2], 1, [][]string{paths[:1], paths[1:2]}},
+		{"zero size still makes progress", paths[:2], 0, [][]string{paths[:1], paths[1:2]}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := chunk(tt.paths, countStrategy(tt.size)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("chunk = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPurgeRejectsUnknownBatchStrategy(t *testing.T) {
+	mock := newMockCDN(t, func(mockRequest) map[string]interface{} {
+		return taskResponse("task-1", "req-1")
+	})
+	client := mock.client(t)
+
+	_, err := client.Purge(context.Background(), Config{Paths: []string{"https://e.com/1/"}, FlushType: FlushTypeFlush, BatchStrategy: "bytes"})
+	if err == nil || !strings.Contains(err.Error(), `unknown batch strategy "bytes"`) {
+		t.Fatalf("Purge error = %v, want the unknown strategy", err)
+	}
+	if len(mock.requests) != 0 {
+		t.Errorf("submitted %d requests, want none", len(mock.requests))
+	}
+}
+
+func TestPartitionEndpoint(t *testing.T) {
+	tests := []struct {
+		partition, region string
+		want              string
+		wantErr           bool
+	}{
+		{"", "", DefaultEndpoint, false},
+		{PartitionCN, "ap-guangzhou", DefaultEndpoint, false},
+		{PartitionIntl, "ap-singapore", "cdn.intl.tencentcloudapi.com", false},
+		{PartitionFinance, "ap-shanghai-fsi", "cdn.ap-shanghai-fsi.tencentcloudapi.com", false},
+		{PartitionFinance, "ap-shanghai", "", true},
+		{"mars", "", "", true},
+	}
+	for _, tt := range tests {
+		got, err := PartitionEndpoint(tt.partition, tt.region)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("PartitionEndpoint(%q, %q) = %q, %v", tt.partition, tt.region, got, err)
+		}
+	}
+}
+
+// BenchmarkPurgeConnectionReuse submits single-path batches from several workers with the
+// default transport, with Go's default of two idle connections per host and with a new
+// connection per request
+func BenchmarkPurgeConnectionReuse(b *testing.B) {
+	paths := make([]string, 200)
+	for i := range paths {
+		paths[i] = fmt.Sprintf("https://example.com/%d/", i)
+	}
+	benchmarks := []struct {
+		name string
+		http TransportOptions
+	}{
+		{"keep-alive", TransportOptions{}},
+		{"two-idle-per-host", TransportOptions{MaxIdleConnsPerHost: 2}},
+		{"no-keep-alive", TransportOptions{DisableKeepAlives: true}},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			mock := newMockCDN(b, func(mockRequest) map[string]interface{} {
+				return taskResponse("task-1", "req-1")
+			})
+			client, err := NewClient(Options{
+				SecretID:          "id",
+				SecretKey:         "key",
+				Endpoint:          strings.TrimPrefix(mock.server.URL, "http://"),
+				Scheme:            "http",
+				RequestsPerSecond: 1e9,
+				HTTP:              bm.http,
+			})
+			if err != nil {
+				b.Fatalf("NewClient: %v", err)
+			}
+			cfg := Config{Paths: paths, FlushType: FlushTypeFlush, BatchSize: 1, Concurrency: 8}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := client.Purge(context.Background(), cfg); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(b.N*len(paths))/b.Elapsed().Seconds(), "batches/s")
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"strings"
+	"time"
+
+	tencentCloudSDKErrors "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/errors"
+)
+
+// DefaultRetryableCodes are SDK error codes that usually succeed when retried, used when
+// Options.RetryableCodes is nil. An entry also matches its sub-codes, so InternalError covers
+// InternalError.CdnSystemError.
+var DefaultRetryableCodes = []string{
+	"RequestLimitExceeded",
+	"InternalError",
+	"ClientError.NetworkError",
+}
+
+// isRetryable reports whether err is an SDK error whose code is in the retryable set
+func isRetryable(err error, codes []string) bool {
+	return hasErrorCode(err, codes)
+}
+
+// batchLimitCodes are the SDK error codes of a batch holding more paths than the API
+// accepts in a single call
+var batchLimitCodes = []string{
+	"LimitExceeded.CdnPurgeExceedBatchLimit",
+	"LimitExceeded.CdnPurgePathExceedBatchLimit",
+	"LimitExceeded.CdnPurgeUrlExceedBatchLimit",
+}
+
+// IsBatchLimitError reports whether err rejected a batch for holding more paths than the
+// API accepts in a single call
+func IsBatchLimitError(err error) bool {
+	return hasErrorCode(err, batchLimitCodes)
+}
+
+// hasErrorCode reports whether err is an SDK error whose code, or the code it is a
+// sub-code of, is one of codes
+func hasErrorCode(err error, codes []string) bool {
+	var tencentCloudSDKError *tencentCloudSDKErrors.TencentCloudSDKError
+	if !errors.As(err, &tencentCloudSDKError) {
+		return false
+	}
+	for _, code := range codes {
+		if tencentCloudSDKError.Code == code || strings.HasPrefix(tencentCloudSDKError.Code, code+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// backoffDelay returns the wait before the given retry attempt, doubling the base
+// delay each time and randomizing the upper half to spread out concurrent clients
+func backoffDelay(base time.Duration, attempt int) time.Duration {
+	delay := base << attempt
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// retryableCodes returns the error codes retried by the client
+func (c *Client) retryableCodes() []string {
+	if c.opts.RetryableCodes == nil {
+		return DefaultRetryableCodes
+	}
+	return c.opts.RetryableCodes
+}
+
+// withRetry calls fn until it succeeds, fails with a non-retryable error,
+// the configured number of retries is exhausted or ctx is done
+func (c *Client) withRetry(ctx context.Context, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || ctx.Err() != nil || attempt >= c.opts.MaxRetries || !isRetryable(err, c.retryableCodes()) {
+			return err
+		}
+		delay := backoffDelay(c.opts.RetryBaseDelay, attempt)
+		c.logger.Info("retrying API call", "attempt", attempt+1, "delay", delay, "error", err)
+		if err := SleepContext(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// SleepContext waits for d, returning ctx.Err() early if ctx is done first. Retries and the
+// rate limit wait with it, and so can callers polling the API.
+func SleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
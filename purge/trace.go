@@ -0,0 +1,27 @@
+package purge
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer records a span per submitted batch. It uses the global tracer provider, which is a
+// no-op unless the application installs one, so tracing costs nothing when unconfigured.
+var tracer = otel.Tracer("git.ghink.net/ghink/PurgeCOSPathCache/purge")
+
+// endSpan records the outcome of an API call on span and ends it
+func endSpan(span trace.Span, requestID string, err error) {
+	if err != nil {
+		if ids := RequestIDs(err); len(ids) > 0 {
+			requestID = ids[0]
+		}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, DescribeError(err))
+	}
+	if requestID != "" {
+		span.SetAttributes(attribute.String("tencentcloud.request_id", requestID))
+	}
+	span.End()
+}
@@ -0,0 +1,91 @@
+package purge
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Transport defaults. The SDK's own transport, which this one replaces, closes idle
+// connections after 30 seconds; the per-host idle limit is raised from Go's default of 2 so
+// that concurrent batches reuse their connections instead of redialing.
+const (
+	DefaultMaxIdleConns        = 100
+	DefaultMaxIdleConnsPerHost = 16
+	DefaultIdleConnTimeout     = 30 * time.Second
+	DefaultKeepAlive           = 30 * time.Second
+)
+
+// dialTimeout bounds connection setup, as in http.DefaultTransport
+const dialTimeout = 30 * time.Second
+
+// TransportOptions tunes the HTTP connections to the API. Zero values select the defaults.
+type TransportOptions struct {
+	// MaxIdleConns caps the idle connections kept open, MaxIdleConnsPerHost those to the API host
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	// IdleConnTimeout closes connections idle for longer
+	IdleConnTimeout time.Duration
+	// KeepAlive is the TCP keep-alive probe interval, negative disables probes
+	KeepAlive time.Duration
+	// DisableKeepAlives opens a new connection for every request
+	DisableKeepAlives bool
+	// DisableHTTP2 sticks to HTTP/1.1 even when the endpoint offers HTTP/2
+	DisableHTTP2 bool
+}
+
+// userAgentTransport sets the User-Agent header of every request before passing it on
+type userAgentTransport struct {
+	base      http.RoundTripper
+	userAgent string
+}
+
+func (t *userAgentTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	// A RoundTripper must not modify the caller's request
+	request = request.Clone(request.Context())
+	request.Header.Set("User-Agent", t.userAgent)
+	return t.base.RoundTrip(request)
+}
+
+// newTransport builds the HTTP transport of the API client from opts. Without an explicit
+// proxy it follows HTTP_PROXY, HTTPS_PROXY and NO_PROXY like http.DefaultTransport. A Client
+// keeps a single transport, so its connections are shared by every batch and worker.
+func newTransport(opts Options) (http.RoundTripper, error) {
+	tuning := opts.HTTP
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   dialTimeout,
+		KeepAlive: valueOr(tuning.KeepAlive, DefaultKeepAlive),
+	}).DialContext
+	transport.MaxIdleConns = valueOr(tuning.MaxIdleConns, DefaultMaxIdleConns)
+	transport.MaxIdleConnsPerHost = valueOr(tuning.MaxIdleConnsPerHost, DefaultMaxIdleConnsPerHost)
+	transport.IdleConnTimeout = valueOr(tuning.IdleConnTimeout, DefaultIdleConnTimeout)
+	transport.DisableKeepAlives = tuning.DisableKeepAlives
+	if tuning.DisableHTTP2 {
+		// A non-nil empty map turns off the automatic HTTP/2 upgrade
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	if opts.Proxy != "" {
+		proxy, err := url.Parse(opts.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	if opts.UserAgent == "" {
+		return transport, nil
+	}
+	return &userAgentTransport{base: transport, userAgent: opts.UserAgent}, nil
+}
+
+// valueOr returns value, or fallback when value is zero
+func valueOr[T comparable](value, fallback T) T {
+	var zero T
+	if value == zero {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	cdn "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/cdn/v20180606"
+
+	"git.ghink.net/ghink/PurgeCOSPathCache/purge"
+)
+
+// Areas reported by DescribePurgeQuota
+const (
+	areaMainland = "mainland"
+	areaOverseas = "overseas"
+	areaGlobal   = "global"
+)
+
+// printPurgeQuota prints the available and used quota for both purge types
+func printPurgeQuota(quota *cdn.DescribePurgeQuotaResponseParams) {
+	out.Printf("Purge quota:\n")
+	printQuotaEntries(purgeModePath, quota.PathPurge)
+	printQuotaEntries(purgeModeURL, quota.UrlPurge)
+}
+
+// quotaLowFraction is the share of the daily quota below which the remaining quota is flagged
+const quotaLowFraction = 0.1
+
+// printQuotaEntries prints one line per area for the given purge type, highlighting
+// areas that are running low on quota
+func printQuotaEntries(purgeType string, entries []*cdn.Quota) {
+	for _, entry := range newQuotaEntries(entries) {
+		line := fmt.Sprintf("  %s %s: available %d of %d, used %d, batch limit %d",
+			purgeType, entry.Area, entry.Available, entry.Total, entry.Used, entry.Batch)
+		if float64(entry.Available) < quotaLowFraction*float64(entry.Total) {
+			out.Warnf("%s, running low\n", line)
+			continue
+		}
+		out.Printf("%s\n", line)
+	}
+}
+
+// quotaAreaMatches reports whether a quota entry area is targeted by the configured purge area
+func quotaAreaMatches(quotaArea, purgeArea string) bool {
+	switch purgeArea {
+	case "":
+		// Omitting the area purges mainland nodes only
+		return quotaArea == areaMainland
+	case areaGlobal:
+		return true
+	default:
+		return quotaArea == purgeArea
+	}
+}
+
+// describePurgeQuota fetches the purge quota of an account
+func describePurgeQuota(ctx context.Context, acct *account) (*cdn.DescribePurgeQuotaResponseParams, error) {
+	var response *cdn.DescribePurgeQuotaResponse
+	err := acct.call(ctx, "DescribePurgeQuota", func() (err error) {
+		response, err = acct.client.DescribePurgeQuotaWithContext(ctx, cdn.NewDescribePurgeQuotaRequest())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	// A response without its body is treated as listing no quota
+	if response.Response == nil {
+		return &cdn.DescribePurgeQuotaResponseParams{}, nil
+	}
+	return response.Response, nil
+}
+
+// checkPurgeQuota fetches the purge quota, prints it and verifies enough quota remains
+// for the configured paths
+func checkPurgeQuota(ctx context.Context, acct *account, config *Config) error {
+	quota, err := describePurgeQuota(ctx, acct)
+	if err != nil {
+		return err
+	}
+	printPurgeQuota(quota)
+	required := len(config.PurgeConfig.Paths)
+	for _, entry := range targetedQuota(quota, config) {
+		out.Printf("This run will use %d of %d remaining %s quota in %s\n", required, intValue(entry.Available), purgeQuotaName(config.PurgeConfig.PurgeMode), stringValue(entry.Area))
+	}
+	return requireQuota(quota, config, required)
+}
+
+// targetedQuota returns the quota entries consumed by the configured mode and area
+func targetedQuota(quota *cdn.DescribePurgeQuotaResponseParams, config *Config) []*cdn.Quota {
+	// Pick the quota bucket matching the configured purge mode
+	entries := quota.PathPurge
+	if config.PurgeConfig.PurgeMode == purgeModeURL {
+		entries = quota.UrlPurge
+	}
+	var targeted []*cdn.Quota
+	for _, entry := range entries {
+		if entry != nil && quotaAreaMatches(stringValue(entry.Area), config.PurgeConfig.Area) {
+			targeted = append(targeted, entry)
+		}
+	}
+	return targeted
+}
+
+// purgeQuotaName names the daily quota a purge mode consumes, every URL or directory
+// counting once
+func purgeQuotaName(purgeMode string) string {
+	if purgeMode == purgeModeURL {
+		return "URL purge"
+	}
+	return "directory purge"
+}
+
+// requireQuota verifies that every quota entry targeted by the configured mode and area
+// has at least required paths available
+func requireQuota(quota *cdn.DescribePurgeQuotaResponseParams, config *Config, required int) error {
+	for _, entry := range targetedQuota(quota, config) {
+		if intValue(entry.Available) < int64(required) {
+			return fmt.Errorf("%w for %s in %s: %d paths requested but only %d available",
+				errInsufficientQuota, config.PurgeConfig.PurgeMode, stringValue(entry.Area), required, intValue(entry.Available))
+		}
+	}
+	return nil
+}
+
+// Bounds of the wait_for_quota loop
+const (
+	defaultQuotaWaitTimeout = 1800 // seconds
+	quotaPollInterval       = 30 * time.Second
+)
+
+// isQuotaBatch reports whether a batch was rejected for exhausted quota
+func isQuotaBatch(batch purge.BatchResult) bool {
+	return isQuotaError(batch.Err)
+}
+
+// resubmitAfterQuota handles the error of a purge when wait_for_quota is set: while batches
+// are rejected for exhausted quota, it waits until DescribePurgeQuota reports enough quota
+// for them and resubmits them, giving up once quota_wait_timeout elapses
+func resubmitAfterQuota(ctx context.Context, acct *account, config *Config, result *purge.Result, err error) error {
+	deadline := time.Now().Add(time.Duration(config.PurgeConfig.QuotaWaitTimeout) * time.Second)
+	purgeConfig := newPurgeConfig(config)
+	for resubmitted := false; err != nil; resubmitted = true {
+		required := 0
+		for _, batch := range result.Batches {
+			if isQuotaBatch(batch) {
+				required += len(batch.Paths)
+			}
+		}
+		if required == 0 {
+			return err
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return fmt.Errorf("%w: quota_wait_timeout of %ds elapsed: %w", errInsufficientQuota, config.PurgeConfig.QuotaWaitTimeout, err)
+		}
+		// The reported quota can lag behind, or be shared with other accounts, so a batch
+		// rejected again is not resubmitted before the next poll
+		if resubmitted {
+			logger.Warn("resubmitted batches rejected for quota again", "paths", required, "remaining", remaining.Round(time.Second))
+			if sleepErr := purge.SleepContext(ctx, min(quotaPollInterval, remaining)); sleepErr != nil {
+				return fmt.Errorf("%w: %w", sleepErr, err)
+			}
+		}
+		if waitErr := waitForQuota(ctx, acct, config, required, deadline); waitErr != nil {
+			return fmt.Errorf("%w: %w", waitErr, err)
+		}
+		err = acct.purger.Resubmit(ctx, purgeConfig, result, isQuotaBatch)
+	}
+	return nil
+}
+
+// waitForQuota polls DescribePurgeQuota until required paths can be purged, failing with
+// errInsufficientQuota once deadline passes
+func waitForQuota(ctx context.Context, acct *account, config *Config, required int, deadline time.Time) error {
+	for {
+		quota, err := describePurgeQuota(ctx, acct)
+		if err != nil {
+			return err
+		}
+		shortage := requireQuota(quota, config, required)
+		if shortage == nil {
+			logger.Info("purge quota available, resubmitting", "paths", required)
+			return nil
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return fmt.Errorf("quota_wait_timeout of %ds elapsed: %w", config.PurgeConfig.QuotaWaitTimeout, shortage)
+		}
+		logger.Warn("waiting for purge quota", "paths", required, "reason", shortage, "remaining", remaining.Round(time.Second))
+		if err := purge.SleepContext(ctx, min(quotaPollInterval, remaining)); err != nil {
+			return err
+		}
+	}
+}
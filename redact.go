@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/url"
+
+	"git.ghink.net/ghink/PurgeCOSPathCache/purge"
+)
+
+// redactPaths hides purge paths from logs and text output, set by -redact or
+// purge_config.redact_paths
+var redactPaths bool
+
+// pathsRedacted reports whether paths are hidden, which debug logging overrides
+func pathsRedacted() bool {
+	return redactPaths && !logger.Enabled(context.Background(), slog.LevelDebug)
+}
+
+// displayPath returns path as shown in logs and text output: a short hash of it when paths
+// are redacted, which still tells paths apart and matches across runs
+func displayPath(path string) string {
+	if !pathsRedacted() {
+		return path
+	}
+	sum := sha256.Sum256([]byte(path))
+	return "redacted-" + hex.EncodeToString(sum[:6])
+}
+
+// redactedURL returns rawURL with the password of its user info masked, as proxy URLs
+// often carry credentials
+func redactedURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "(unparsable URL)"
+	}
+	return parsed.Redacted()
+}
+
+// displayPaths applies displayPath to every path
+func displayPaths(paths []string) []string {
+	if !pathsRedacted() {
+		return paths
+	}
+	shown := make([]string, len(paths))
+	for i, path := range paths {
+		shown[i] = displayPath(path)
+	}
+	return shown
+}
+
+// renderBatchRequest renders the request body of a batch for display, with the paths
+// redacted when requested. The flush type is resolved from the real paths first.
+func renderBatchRequest(cfg purge.Config, batch []string) string {
+	if !pathsRedacted() {
+		return purge.RenderRequest(cfg, batch)
+	}
+	if flushType, ok := cfg.FlushTypes[batch[0]]; ok {
+		cfg.FlushType = flushType
+	}
+	cfg.FlushTypes = nil
+	return purge.RenderRequest(cfg, displayPaths(batch))
+}
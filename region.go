@@ -0,0 +1,170 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+
+	"git.ghink.net/ghink/PurgeCOSPathCache/purge"
+)
+
+// knownRegions lists the Tencent Cloud regions accepted by the API. CDN is a global service,
+// so the region only affects request signing and does not select the nodes purged.
+var knownRegions = []string{
+	"ap-bangkok",
+	"ap-beijing",
+	"ap-beijing-fsi",
+	"ap-chengdu",
+	"ap-chongqing",
+	"ap-guangzhou",
+	"ap-hongkong",
+	"ap-jakarta",
+	"ap-mumbai",
+	"ap-nanjing",
+	"ap-seoul",
+	"ap-shanghai",
+	"ap-shanghai-fsi",
+	"ap-shenzhen-fsi",
+	"ap-singapore",
+	"ap-taipei",
+	"ap-tokyo",
+	"eu-frankfurt",
+	"eu-moscow",
+	"na-ashburn",
+	"na-siliconvalley",
+	"na-toronto",
+	"sa-saopaulo",
+}
+
+// mainlandRegions are the knownRegions located in mainland China
+var mainlandRegions = []string{
+	"ap-beijing",
+	"ap-beijing-fsi",
+	"ap-chengdu",
+	"ap-chongqing",
+	"ap-guangzhou",
+	"ap-nanjing",
+	"ap-shanghai",
+	"ap-shanghai-fsi",
+	"ap-shenzhen-fsi",
+}
+
+// overseasHostLabels are hostname labels suggesting a site serves international traffic
+var overseasHostLabels = []string{"intl", "international", "global", "overseas", "en"}
+
+// genericCountryTLDs are country code top-level domains commonly used by sites of any country
+var genericCountryTLDs = []string{"ai", "cc", "co", "io", "me", "tv"}
+
+// isKnownRegion reports whether region is empty or one of knownRegions
+func isKnownRegion(region string) bool {
+	if region == "" {
+		return true
+	}
+	for _, known := range knownRegions {
+		if region == known {
+			return true
+		}
+	}
+	return false
+}
+
+// suggestRegion returns the known region closest to a mistyped one, or an empty string
+// when none is within a few edits
+func suggestRegion(region string) string {
+	const maxDistance = 3
+	best, bestDistance := "", maxDistance+1
+	for _, known := range knownRegions {
+		if d := editDistance(strings.ToLower(region), known); d < bestDistance {
+			best, bestDistance = known, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+// isOverseasHost reports whether a hostname looks like it serves international traffic:
+// one of its labels is in overseasHostLabels or it ends in a country code other than cn
+func isOverseasHost(host string) bool {
+	labels := strings.Split(strings.ToLower(strings.TrimSuffix(host, ".")), ".")
+	for _, label := range labels[:len(labels)-1] {
+		for _, hint := range overseasHostLabels {
+			if label == hint {
+				return true
+			}
+		}
+	}
+	tld := labels[len(labels)-1]
+	if len(labels) < 2 || len(tld) != 2 || tld == "cn" {
+		return false
+	}
+	for _, generic := range genericCountryTLDs {
+		if tld == generic {
+			return false
+		}
+	}
+	return true
+}
+
+// warnMainlandOnly warns when area is omitted, which purges mainland China nodes only, while
+// a profile region or partition or a path host hints that overseas nodes serve the site too.
+// It stays a heuristic warning since mainland-only purges are a valid choice.
+func warnMainlandOnly(config *Config) {
+	if len(config.PurgeConfig.Areas) > 0 {
+		return
+	}
+	var hints []string
+	for _, cloud := range config.TencentCloud {
+		switch {
+		case cloud.Partition == purge.PartitionIntl:
+			hints = append(hints, "profile "+cloud.Name+" uses partition intl")
+		case cloud.Region != "" && isKnownRegion(cloud.Region) && !isMainlandRegion(cloud.Region):
+			hints = append(hints, "profile "+cloud.Name+" uses region "+cloud.Region)
+		}
+	}
+	var hosts []string
+	seen := map[string]bool{}
+	for _, path := range config.PurgeConfig.Paths {
+		parsed, err := url.Parse(path)
+		if err != nil || parsed.Hostname() == "" || seen[parsed.Hostname()] {
+			continue
+		}
+		seen[parsed.Hostname()] = true
+		if isOverseasHost(parsed.Hostname()) {
+			hosts = append(hosts, parsed.Hostname())
+		}
+	}
+	if len(hints) == 0 && len(hosts) == 0 {
+		return
+	}
+	logger.Warn("purge_config.area is not set, so ONLY MAINLAND CHINA nodes will be purged and overseas nodes keep serving stale content; set area: global to purge them too",
+		"hints", hints, "hosts", hosts[:min(len(hosts), maxWarningExamples)])
+}
+
+// isMainlandRegion reports whether region is located in mainland China
+func isMainlandRegion(region string) bool {
+	for _, mainland := range mainlandRegions {
+		if region == mainland {
+			return true
+		}
+	}
+	return false
+}
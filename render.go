@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+
+	"git.ghink.net/ghink/PurgeCOSPathCache/purge"
+)
+
+// purgeAction returns the API action submitting purges in the given mode
+func purgeAction(mode string) string {
+	if mode == purgeModeURL {
+		return "PurgeUrlsCache"
+	}
+	return "PurgePathCache"
+}
+
+// renderFlags are the flags of the render command
+type renderFlags struct {
+	*flag.FlagSet
+	autoScheme *bool
+}
+
+// newRenderFlags creates the flag set of the render command
+func newRenderFlags() *renderFlags {
+	fs := newFlagSet("render", "", "Print the API requests the configuration resolves to, one per batch, without credentials or API calls.")
+	return &renderFlags{
+		FlagSet:    fs,
+		autoScheme: fs.Bool("auto-scheme", false, "Prepend https:// to paths without a protocol header instead of failing"),
+	}
+}
+
+// renderCommand prints the request bodies the configuration resolves to, one per batch,
+// without credentials or API calls
+func renderCommand(ctx context.Context, source *configSource, args []string) {
+	flags := newRenderFlags()
+	flags.Parse(args)
+
+	// Sitemaps are not fetched, so rendering needs no network access
+	source.offline = true
+	config := loadCommandConfig(ctx, source)
+	normalizeConfigPaths(config, *flags.autoScheme)
+	warnPathModes(config.PurgeConfig.Paths, config.PurgeConfig.PurgeMode)
+	warnMainlandOnly(config)
+	warnRedundantAreas(config.PurgeConfig.Areas)
+	if err := errors.Join(validatePurgeConfig(config), validateConflicts(config)); err != nil {
+		out.Exitf(exitConfig, "Configuration validation failed: %v", err)
+	}
+
+	batches := purge.Batches(newPurgeConfig(config))
+	summary := renderSummary{PurgeRequests: []renderedRequest{}}
+	action := purgeAction(config.PurgeConfig.PurgeMode)
+	areas := config.PurgeConfig.Areas.targets()
+	out.Printf("%s (purge_mode %s): %d paths in %d batches of at most %d paths\n",
+		action, config.PurgeConfig.PurgeMode, len(config.PurgeConfig.Paths), len(batches), batchSize(config))
+	// Each area is purged with requests of its own
+	for _, area := range areas {
+		if len(areas) > 1 {
+			out.Printf("Area %s:\n", area)
+		}
+		purgeConfig := newPurgeConfig(areaConfig(config, area))
+		for i, batch := range batches {
+			rendered := renderedRequest{
+				Action:  action,
+				Batch:   i + 1,
+				Area:    area,
+				Paths:   len(batch),
+				Request: json.RawMessage(renderBatchRequest(purgeConfig, batch)),
+			}
+			if config.PurgeConfig.PurgeMode == purgeModePath {
+				rendered.FlushType = config.PurgeConfig.FlushType
+				if flushType, ok := config.PurgeConfig.FlushTypes[batch[0]]; ok {
+					rendered.FlushType = flushType
+				}
+				out.Printf("Batch %d/%d, %d paths, flush_type %s:\n%s\n", i+1, len(batches), len(batch), rendered.FlushType, rendered.Request)
+			} else {
+				out.Printf("Batch %d/%d, %d paths:\n%s\n", i+1, len(batches), len(batch), rendered.Request)
+			}
+			summary.PurgeRequests = append(summary.PurgeRequests, rendered)
+		}
+	}
+
+	if len(config.PushConfig.Urls) > 0 {
+		if err := validatePushConfig(config); err != nil {
+			out.Exitf(exitConfig, "Configuration validation failed: %v", err)
+		}
+		summary.PushRequest = &renderedRequest{
+			Action:  "PushUrlsCache",
+			Paths:   len(config.PushConfig.Urls),
+			Request: json.RawMessage(newPushRequest(config).ToJsonString()),
+		}
+		out.Printf("PushUrlsCache: %d URLs\n%s\n", summary.PushRequest.Paths, summary.PushRequest.Request)
+	}
+	out.Result(summary)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// sitemapFetchTimeout bounds the download of each sitemap file
+const sitemapFetchTimeout = 30 * time.Second
+
+// maxSitemapDepth limits how deeply sitemap index files may nest
+const maxSitemapDepth = 3
+
+// maxSitemapBytes is the uncompressed size limit of a sitemap file set by the sitemap protocol
+const maxSitemapBytes = 50 << 20
+
+// sitemapLastmodLayouts are the W3C datetime forms accepted for lastmod
+var sitemapLastmodLayouts = []string{time.RFC3339, "2006-01-02T15:04Z07:00", "2006-01-02"}
+
+// sitemapEntry is a <url> of a urlset or a <sitemap> of a sitemap index
+type sitemapEntry struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+// sitemapDocument decodes both a urlset and a sitemap index
+type sitemapDocument struct {
+	URLs     []sitemapEntry `xml:"url"`
+	Sitemaps []sitemapEntry `xml:"sitemap"`
+}
+
+// parseLastmod parses a sitemap lastmod value
+func parseLastmod(value string) (time.Time, error) {
+	for _, layout := range sitemapLastmodLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid lastmod %q", value)
+}
+
+// changedSince reports whether an entry may have changed after cutoff. Entries without
+// a lastmod are kept, since they cannot be proven unchanged.
+func (e sitemapEntry) changedSince(cutoff time.Time) bool {
+	if cutoff.IsZero() || e.LastMod == "" {
+		return true
+	}
+	lastmod, err := parseLastmod(e.LastMod)
+	return err != nil || lastmod.After(cutoff)
+}
+
+// fetchSitemap downloads and decodes one sitemap file, decompressing gzip content
+func fetchSitemap(ctx context.Context, client *http.Client, sitemapURL string) (*sitemapDocument, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, sitemapURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sitemap URL: %v", err)
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch sitemap: %v", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch sitemap %s: %s", sitemapURL, response.Status)
+	}
+	data, err := readSitemap(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sitemap %s: %v", sitemapURL, err)
+	}
+
+	// Compressed sitemaps (usually *.xml.gz) are recognized by the gzip magic bytes
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress sitemap %s: %v", sitemapURL, err)
+		}
+		data, err = readSitemap(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress sitemap %s: %v", sitemapURL, err)
+		}
+	}
+
+	var document sitemapDocument
+	if err := xml.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("failed to parse sitemap %s: %v", sitemapURL, err)
+	}
+	return &document, nil
+}
+
+// readSitemap reads a sitemap file, failing rather than truncating it beyond maxSitemapBytes
+func readSitemap(reader io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(reader, maxSitemapBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxSitemapBytes {
+		return nil, fmt.Errorf("sitemap exceeds %d MB", maxSitemapBytes>>20)
+	}
+	return data, nil
+}
+
+// sitemapURLs returns the <loc> of every URL listed by the sitemap, following sitemap index
+// files, keeping only entries changed after cutoff when it is set
+func sitemapURLs(ctx context.Context, sitemapURL string, cutoff time.Time) ([]string, error) {
+	client := &http.Client{Timeout: sitemapFetchTimeout}
+	return collectSitemapURLs(ctx, client, sitemapURL, cutoff, 0)
+}
+
+func collectSitemapURLs(ctx context.Context, client *http.Client, sitemapURL string, cutoff time.Time, depth int) ([]string, error) {
+	if depth > maxSitemapDepth {
+		return nil, fmt.Errorf("sitemap index nesting exceeds %d levels at %s", maxSitemapDepth, sitemapURL)
+	}
+	document, err := fetchSitemap(ctx, client, sitemapURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var urls []string
+	for _, entry := range document.URLs {
+		if entry.Loc != "" && entry.changedSince(cutoff) {
+			urls = append(urls, entry.Loc)
+		}
+	}
+	// A sub-sitemap unchanged since the cutoff lists no changed URLs
+	for _, entry := range document.Sitemaps {
+		if entry.Loc == "" || !entry.changedSince(cutoff) {
+			continue
+		}
+		nested, err := collectSitemapURLs(ctx, client, entry.Loc, cutoff, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, nested...)
+	}
+	return urls, nil
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+)
+
+// defaultDedupeWindow is the dedupe_window applied when unset, in seconds
+const defaultDedupeWindow = 300
+
+// purgeState is the state file, recording when each path was last purged, the runs
+// submitted under an idempotency key, the paths of the last run and the quota used
+type purgeState struct {
+	Paths   map[string]time.Time `json:"paths"`
+	Keys    map[string]keyRecord `json:"keys,omitempty"`
+	LastRun *runRecord           `json:"last_run,omitempty"`
+	// QuotaUsage totals the quota used per month, as 2006-01, and purge mode
+	QuotaUsage map[string]map[string]int `json:"quota_usage,omitempty"`
+}
+
+// quotaUsageMonths is how many months of quota usage the state file keeps
+const quotaUsageMonths = 12
+
+// runRecord is the full normalized path set of a run, which -diff compares against
+type runRecord struct {
+	RecordedAt time.Time `json:"recorded_at"`
+	Paths      []string  `json:"paths"`
+}
+
+// keyRecord is a successful run submitted under an idempotency key
+type keyRecord struct {
+	SubmittedAt time.Time  `json:"submitted_at"`
+	Result      runSummary `json:"result"`
+}
+
+// idempotencyKeyTTL is how long a recorded idempotency key short-circuits repeated runs
+const idempotencyKeyTTL = 24 * time.Hour
+
+// idempotencyKeyAuto derives the idempotency key from the purge configuration
+const idempotencyKeyAuto = "auto"
+
+// stateFile is an open state file, locked against concurrent runs until closed
+type stateFile struct {
+	path  string
+	lock  *os.File
+	state purgeState
+}
+
+// openStateFile locks the state file and loads it, starting empty when it does not exist yet.
+// The lock is taken on a separate .lock file so the state itself can be replaced atomically,
+// and it is held for the whole run so concurrent runs see each other's purges.
+func openStateFile(path string) (*stateFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	lock, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(lock, path); err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("failed to lock state file: %v", err)
+	}
+
+	s := &stateFile{path: path, lock: lock, state: purgeState{Paths: map[string]time.Time{}}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &s.state)
+	}
+	if err != nil {
+		s.Close()
+		return nil, fmt.Errorf("failed to read state file %s: %v", path, err)
+	}
+	if s.state.Paths == nil {
+		s.state.Paths = map[string]time.Time{}
+	}
+	return s, nil
+}
+
+// skipRecent removes the paths purged within window before now and returns the remaining
+// ones along with the number skipped
+func (s *stateFile) skipRecent(paths []string, window time.Duration, now time.Time) ([]string, int) {
+	remaining := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if purgedAt, ok := s.state.Paths[path]; ok && now.Sub(purgedAt) < window {
+			continue
+		}
+		remaining = append(remaining, path)
+	}
+	return remaining, len(paths) - len(remaining)
+}
+
+// submitted returns the run recorded under key within idempotencyKeyTTL before now
+func (s *stateFile) submitted(key string, now time.Time) (keyRecord, bool) {
+	record, ok := s.state.Keys[key]
+	if !ok || now.Sub(record.SubmittedAt) >= idempotencyKeyTTL {
+		return keyRecord{}, false
+	}
+	return record, true
+}
+
+// rememberKey records a successful run under key, written out by the next record
+func (s *stateFile) rememberKey(key string, result runSummary, now time.Time) {
+	if s.state.Keys == nil {
+		s.state.Keys = map[string]keyRecord{}
+	}
+	s.state.Keys[key] = keyRecord{SubmittedAt: now, Result: result}
+}
+
+// rememberRun records the path set of the current run, written out by the next record
+func (s *stateFile) rememberRun(paths []string, now time.Time) {
+	sorted := slices.Clone(paths)
+	sort.Strings(sorted)
+	s.state.LastRun = &runRecord{RecordedAt: now, Paths: slices.Compact(sorted)}
+}
+
+// addQuotaUsage adds the quota used by a run to the total of its month and purge mode,
+// written out by the next record, and returns the new total. Months older than
+// quotaUsageMonths are dropped.
+func (s *stateFile) addQuotaUsage(purgeMode string, used int, now time.Time) int {
+	if s.state.QuotaUsage == nil {
+		s.state.QuotaUsage = map[string]map[string]int{}
+	}
+	month := now.UTC().Format("2006-01")
+	if s.state.QuotaUsage[month] == nil {
+		s.state.QuotaUsage[month] = map[string]int{}
+	}
+	s.state.QuotaUsage[month][purgeMode] += used
+
+	year, current, _ := now.UTC().Date()
+	oldest := time.Date(year, current-quotaUsageMonths+1, 1, 0, 0, 0, 0, time.UTC).Format("2006-01")
+	for recorded := range s.state.QuotaUsage {
+		if recorded < oldest {
+			delete(s.state.QuotaUsage, recorded)
+		}
+	}
+	return s.state.QuotaUsage[month][purgeMode]
+}
+
+// diffLastRun returns the paths added and removed relative to the last recorded run, in
+// order, and the number of paths found in both
+func (s *stateFile) diffLastRun(paths []string) (added, removed []string, unchanged int) {
+	var previous []string
+	if s.state.LastRun != nil {
+		previous = s.state.LastRun.Paths
+	}
+	current := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		current[path] = true
+	}
+	recorded := make(map[string]bool, len(previous))
+	for _, path := range previous {
+		recorded[path] = true
+		if !current[path] {
+			removed = append(removed, path)
+		}
+	}
+	seen := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+		if recorded[path] {
+			unchanged++
+		} else {
+			added = append(added, path)
+		}
+	}
+	return added, removed, unchanged
+}
+
+// record marks the paths as purged at now, prunes entries older than window and expired
+// idempotency keys and writes the state file atomically
+func (s *stateFile) record(paths []string, window time.Duration, now time.Time) error {
+	for _, path := range paths {
+		s.state.Paths[path] = now
+	}
+	for path, purgedAt := range s.state.Paths {
+		if now.Sub(purgedAt) >= window {
+			delete(s.state.Paths, path)
+		}
+	}
+	for key, record := range s.state.Keys {
+		if now.Sub(record.SubmittedAt) >= idempotencyKeyTTL {
+			delete(s.state.Keys, key)
+		}
+	}
+
+	data, err := json.Marshal(s.state)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// Close releases the lock, letting the next run proceed
+func (s *stateFile) Close() error {
+	return s.lock.Close()
+}
+
+// deriveIdempotencyKey hashes everything that determines what a purge submits: the set of
+// paths, their flush types, the purge settings and the profiles. Path order is ignored.
+func deriveIdempotencyKey(config *Config) string {
+	paths := slices.Clone(config.PurgeConfig.Paths)
+	sort.Strings(paths)
+	profiles := make([]string, len(config.TencentCloud))
+	for i, cloud := range config.TencentCloud {
+		profiles[i] = cloud.Name
+	}
+	// Marshalling a map sorts its keys, so the encoding is stable
+	data, _ := json.Marshal(map[string]interface{}{
+		"purge_mode":  config.PurgeConfig.PurgeMode,
+		"flush_type":  config.PurgeConfig.FlushType,
+		"flush_types": config.PurgeConfig.FlushTypes,
+		"url_encode":  config.PurgeConfig.UrlEncode,
+		"area":        config.PurgeConfig.Areas.String(),
+		"paths":       paths,
+		"profiles":    profiles,
+	})
+	sum := sha256.Sum256(data)
+	return idempotencyKeyAuto + "-" + hex.EncodeToString(sum[:8])
+}
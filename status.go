@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	cdn "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/cdn/v20180606"
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+
+	"git.ghink.net/ghink/PurgeCOSPathCache/purge"
+)
+
+// taskTimeLayout is the time format of DescribePurgeTasks, expressed in Beijing time
+const taskTimeLayout = "2006-01-02 15:04:05"
+
+// apiTimeZone is the zone DescribePurgeTasks interprets times in
+var apiTimeZone = time.FixedZone("UTC+8", 8*60*60)
+
+// defaultStatusWindow is how far back status looks when neither task ids nor -start are given
+const defaultStatusWindow = 24 * time.Hour
+
+// parseTaskTime accepts either the API layout, taken as Beijing time, or an RFC 3339
+// timestamp, which is converted to Beijing time
+func parseTaskTime(value string) (string, error) {
+	if _, err := time.ParseInLocation(taskTimeLayout, value, apiTimeZone); err == nil {
+		return value, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return "", fmt.Errorf("invalid time %q, expected %q or RFC 3339", value, taskTimeLayout)
+	}
+	return t.In(apiTimeZone).Format(taskTimeLayout), nil
+}
+
+// statusFilter holds the DescribePurgeTasks filters taken from the status command flags
+type statusFilter struct {
+	start     string
+	end       string
+	keyword   string
+	status    string
+	purgeType string
+}
+
+// newStatusRequest builds a time range query from the filters, defaulting to the last
+// defaultStatusWindow
+func newStatusRequest(filter statusFilter, now time.Time) (*cdn.DescribePurgeTasksRequest, error) {
+	request := cdn.NewDescribePurgeTasksRequest()
+
+	start := now.Add(-defaultStatusWindow).In(apiTimeZone).Format(taskTimeLayout)
+	if filter.start != "" {
+		var err error
+		if start, err = parseTaskTime(filter.start); err != nil {
+			return nil, err
+		}
+	}
+	end := now.In(apiTimeZone).Format(taskTimeLayout)
+	if filter.end != "" {
+		var err error
+		if end, err = parseTaskTime(filter.end); err != nil {
+			return nil, err
+		}
+	}
+	request.StartTime = common.StringPtr(start)
+	request.EndTime = common.StringPtr(end)
+
+	switch filter.status {
+	case "", taskStatusDone, taskStatusFail, taskStatusProcess:
+	default:
+		return nil, fmt.Errorf("unsupported status %q, expected %s, %s or %s", filter.status, taskStatusProcess, taskStatusDone, taskStatusFail)
+	}
+	switch filter.purgeType {
+	case "", purgeModePath, purgeModeURL:
+	default:
+		return nil, fmt.Errorf("unsupported purge type %q, expected %q or %q", filter.purgeType, purgeModePath, purgeModeURL)
+	}
+	if filter.status != "" {
+		request.Status = common.StringPtr(filter.status)
+	}
+	if filter.purgeType != "" {
+		request.PurgeType = common.StringPtr(filter.purgeType)
+	}
+	if filter.keyword != "" {
+		request.Keyword = common.StringPtr(filter.keyword)
+	}
+	return request, nil
+}
+
+// printTaskTable prints task entries as an aligned table
+func printTaskTable(tasks []taskSummary) {
+	if out.format != outputText || out.quiet {
+		return
+	}
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "TASK ID\tURL\tSTATUS\tPURGE TYPE\tCREATE TIME")
+	for _, task := range tasks {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n", task.TaskID, task.URL, task.Status, task.PurgeType, task.CreateTime)
+	}
+	table.Flush()
+}
+
+// statusFlags are the flags of the status command
+type statusFlags struct {
+	*flag.FlagSet
+	filter statusFilter
+}
+
+// newStatusFlags creates the flag set of the status command
+func newStatusFlags() *statusFlags {
+	fs := newFlagSet("status", "[task id...]", "Show purge tasks by id, or those submitted in a time range (default: the last 24 hours).\n"+
+		"Times use the API layout \""+taskTimeLayout+"\" in Beijing time, or RFC 3339.")
+	f := &statusFlags{FlagSet: fs}
+	fs.StringVar(&f.filter.start, "start", "", "Only list tasks submitted at or after this time")
+	fs.StringVar(&f.filter.end, "end", "", "Only list tasks submitted at or before this time (default now)")
+	fs.StringVar(&f.filter.keyword, "keyword", "", "Only list tasks whose URL contains this domain or URL")
+	fs.StringVar(&f.filter.status, "status", "", "Only list tasks with this status: process, done or fail")
+	fs.StringVar(&f.filter.purgeType, "purge-type", "", "Only list tasks of this purge type: path or url")
+	return f
+}
+
+// statusCommand lists purge tasks, either the given ones or those matching the time range filters
+func statusCommand(ctx context.Context, source *configSource, args []string) {
+	flags := newStatusFlags()
+	flags.Parse(args)
+	filter := flags.filter
+	if flags.NArg() > 0 && filter != (statusFilter{}) {
+		out.Exitf(exitConfig, "-start, -end, -keyword, -status and -purge-type filter the tasks of a time range and cannot be combined with task ids")
+	}
+
+	config := loadCommandConfig(ctx, source)
+	if err := validateProfiles(config.TencentCloud); err != nil {
+		out.Exitf(exitConfig, "Configuration validation failed: %v", err)
+	}
+
+	// Task ids are looked up directly, otherwise the filters select a time range
+	var request *cdn.DescribePurgeTasksRequest
+	if flags.NArg() == 0 {
+		var err error
+		if request, err = newStatusRequest(filter, time.Now()); err != nil {
+			out.Exitf(exitConfig, "%v", err)
+		}
+	}
+
+	summary := statusSummary{Tasks: []taskSummary{}}
+	failedProfiles, exitCode := forEachAccount(ctx, config, func(acct *account) error {
+		var tasks []*cdn.PurgeTask
+		if request != nil {
+			var err error
+			if tasks, err = describePurgeTasks(ctx, acct, request); err != nil {
+				return fmt.Errorf("listing tasks failed: %s: %w", purge.DescribeError(err), err)
+			}
+		}
+		for _, taskID := range flags.Args() {
+			taskEntries, err := describePurgeTask(ctx, acct, taskID)
+			if err != nil {
+				return fmt.Errorf("describing task %s failed: %s: %w", taskID, purge.DescribeError(err), err)
+			}
+			if len(taskEntries) == 0 {
+				out.Errorf("Task %s: not found\n", taskID)
+			}
+			tasks = append(tasks, taskEntries...)
+		}
+
+		entries := make([]taskSummary, len(tasks))
+		for i, task := range tasks {
+			entries[i] = newTaskSummary(acct.profile.Name, task)
+		}
+		printTaskTable(entries)
+		summary.Tasks = append(summary.Tasks, entries...)
+		return nil
+	})
+
+	if len(failedProfiles) > 0 {
+		summary.Error = fmt.Sprintf("Status query failed for %d of %d profiles: %s",
+			len(failedProfiles), len(config.TencentCloud), strings.Join(failedProfiles, ", "))
+		out.Fail(exitCode, summary.Error, summary)
+	}
+	out.Result(summary)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"git.ghink.net/ghink/PurgeCOSPathCache/purge"
+)
+
+// Environment variables enabling the OTLP trace exporter, as defined by OpenTelemetry
+const (
+	envOTLPEndpoint       = "OTEL_EXPORTER_OTLP_ENDPOINT"
+	envOTLPTracesEndpoint = "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"
+)
+
+// tracingShutdownTimeout bounds the export of the remaining spans when the run ends
+const tracingShutdownTimeout = 5 * time.Second
+
+// tracer records the run and the API calls made outside the purge package
+var tracer = otel.Tracer("git.ghink.net/ghink/PurgeCOSPathCache")
+
+// runSpan wraps the whole run, ended by finishTracing
+var runSpan trace.Span = trace.SpanFromContext(context.Background())
+
+// shutdownTracing flushes the exported spans, a no-op unless tracing is enabled
+var shutdownTracing = func(context.Context) error { return nil }
+
+// setupTracing installs an OTLP/HTTP trace exporter when OTEL_EXPORTER_OTLP_ENDPOINT or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is set, configured by the standard OTEL_* variables.
+// Otherwise the global tracer provider stays the no-op default and spans cost nothing.
+func setupTracing(ctx context.Context) error {
+	if os.Getenv(envOTLPEndpoint) == "" && os.Getenv(envOTLPTracesEndpoint) == "" {
+		return nil
+	}
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create OTLP trace exporter: %v", err)
+	}
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults
+	res, err := resource.New(ctx,
+		resource.WithAttributes(
+			attribute.String("service.name", "PurgeCOSPathCache"),
+			attribute.String("service.version", version)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK())
+	if err != nil {
+		return fmt.Errorf("failed to describe the trace resource: %v", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	shutdownTracing = provider.Shutdown
+	return nil
+}
+
+// startRunSpan starts the span wrapping the run of a command
+func startRunSpan(ctx context.Context, command string) context.Context {
+	ctx, runSpan = tracer.Start(ctx, "PurgeCOSPathCache "+command, trace.WithAttributes(attribute.String("command", command)))
+	return ctx
+}
+
+// finishTracing ends the run span with the exit code and flushes the exported spans.
+// Export failures are logged only, tracing never changes the outcome of the run.
+func finishTracing(code int, message string) {
+	runSpan.SetAttributes(attribute.Int("exit_code", code))
+	if code != exitOK {
+		runSpan.SetStatus(codes.Error, message)
+	}
+	runSpan.End()
+
+	ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Warn("failed to export traces", "error", err)
+	}
+}
+
+// startCallSpan starts the span of an API call made with acct
+func startCallSpan(ctx context.Context, acct *account, action string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, action, trace.WithAttributes(attribute.String("profile", acct.profile.Name)))
+}
+
+// endCallSpan records the outcome of an API call and ends its span
+func endCallSpan(span trace.Span, err error) {
+	if err != nil {
+		if ids := purge.RequestIDs(err); len(ids) > 0 {
+			span.SetAttributes(attribute.String("tencentcloud.request_id", ids[0]))
+		}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, purge.DescribeError(err))
+	}
+	span.End()
+}
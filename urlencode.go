@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// urlEncodeSetting is url_encode: true asks the API to percent-encode every path, auto
+// encodes the paths that are not encoded yet before submitting them, and false submits the
+// paths as they are. The zero value is unset, which behaves like false once the section
+// settings have fallen back to the top-level one, see resolveURLEncode.
+type urlEncodeSetting string
+
+// Values of urlEncodeSetting
+const (
+	urlEncodeUnset urlEncodeSetting = ""
+	urlEncodeOff   urlEncodeSetting = "false"
+	urlEncodeOn    urlEncodeSetting = "true"
+	urlEncodeAuto  urlEncodeSetting = "auto"
+)
+
+// Set parses true, false or auto, the value of -url-encode and PURGECOS_URL_ENCODE
+func (s *urlEncodeSetting) Set(value string) error {
+	if value == string(urlEncodeAuto) {
+		*s = urlEncodeAuto
+		return nil
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("expected true, false or auto, got %q", value)
+	}
+	*s = urlEncodeOff
+	if enabled {
+		*s = urlEncodeOn
+	}
+	return nil
+}
+
+// String returns the setting as accepted by Set
+func (s urlEncodeSetting) String() string {
+	if s == urlEncodeUnset {
+		return string(urlEncodeOff)
+	}
+	return string(s)
+}
+
+// IsBoolFlag lets a bare -url-encode mean -url-encode=true
+func (s *urlEncodeSetting) IsBoolFlag() bool {
+	return true
+}
+
+// UnmarshalYAML accepts a boolean or auto
+func (s *urlEncodeSetting) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.ScalarNode {
+		return nodeErrorf(node, "url_encode must be true, false or auto")
+	}
+	if err := s.Set(node.Value); err != nil {
+		return nodeErrorf(node, "url_encode must be true, false or auto, got %q", node.Value)
+	}
+	return nil
+}
+
+// UnmarshalJSON accepts a boolean or "auto"
+func (s *urlEncodeSetting) UnmarshalJSON(data []byte) error {
+	var enabled bool
+	if err := json.Unmarshal(data, &enabled); err == nil {
+		return s.Set(strconv.FormatBool(enabled))
+	}
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil || value != string(urlEncodeAuto) {
+		return fmt.Errorf("url_encode must be true, false or auto, got %s", data)
+	}
+	*s = urlEncodeAuto
+	return nil
+}
+
+// UnmarshalTOML accepts a boolean or "auto"
+func (s *urlEncodeSetting) UnmarshalTOML(value interface{}) error {
+	switch v := value.(type) {
+	case bool:
+		return s.Set(strconv.FormatBool(v))
+	case string:
+		if v == string(urlEncodeAuto) {
+			*s = urlEncodeAuto
+			return nil
+		}
+	}
+	return fmt.Errorf("url_encode must be true, false or auto, got %v", value)
+}
+
+// MarshalJSON encodes true and false, unset included, as booleans, as url_encode was before
+// auto existed, so idempotency keys derived from them stay the same
+func (s urlEncodeSetting) MarshalJSON() ([]byte, error) {
+	if s == urlEncodeAuto {
+		return json.Marshal(string(s))
+	}
+	return json.Marshal(s == urlEncodeOn)
+}
+
+// resolveURLEncode applies the top-level url_encode to the purge_config and push_config
+// sections that do not set their own
+func resolveURLEncode(config *Config) {
+	if config.PurgeConfig.UrlEncode == urlEncodeUnset {
+		config.PurgeConfig.UrlEncode = config.UrlEncode
+	}
+	if config.PushConfig.UrlEncode == urlEncodeUnset {
+		config.PushConfig.UrlEncode = config.UrlEncode
+	}
+}
+
+// unescapedURLBytes are the bytes a URL may carry as they are besides letters and digits:
+// the unreserved and reserved characters of RFC 3986
+const unescapedURLBytes = "-._~:/?#[]@!$&'()*+,;="
+
+// encodePath percent-encodes the bytes of path a URL cannot carry as they are, such as
+// spaces and non-ASCII characters, and a % not starting a valid %XX escape. Valid escapes
+// and reserved characters are kept, so an already encoded path is returned unchanged.
+func encodePath(path string) string {
+	var encoded strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		switch {
+		case c == '%' && i+2 < len(path) && isHex(path[i+1]) && isHex(path[i+2]):
+			encoded.WriteByte(c)
+		case c < 0x80 && (isAlphanumeric(c) || strings.IndexByte(unescapedURLBytes, c) >= 0):
+			encoded.WriteByte(c)
+		default:
+			fmt.Fprintf(&encoded, "%%%02X", c)
+		}
+	}
+	return encoded.String()
+}
+
+// isHex reports whether c is a hexadecimal digit
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// isAlphanumeric reports whether c is an ASCII letter or digit
+func isAlphanumeric(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// encodeConfigPaths encodes the paths of an url_encode: auto run that are not encoded yet,
+// logging each one at debug level
+func encodeConfigPaths(config *Config) {
+	encoded := 0
+	for i, path := range config.PurgeConfig.Paths {
+		if escaped := encodePath(path); escaped != path {
+			logger.Debug("encoded path", "path", displayPath(path), "encoded", displayPath(escaped))
+			config.PurgeConfig.Paths[i] = escaped
+			encoded++
+		}
+	}
+	config.PurgeConfig.FlushTypes = rekeyFlushTypes(config.PurgeConfig.FlushTypes, encodePath)
+	logger.Info("encoded paths", "encoded", encoded, "paths", len(config.PurgeConfig.Paths))
+}
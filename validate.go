@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// skipEnumValidation bypasses the checks of flush types, areas, regions and URL schemes
+// against the values this tool knows, set by -no-validate for API additions it does not
+// support yet. Required settings and the structure of values are still checked.
+var skipEnumValidation bool
+
+// valueError is a validation error caused by a specific configuration value, which lets
+// the validate command point at the line holding it
+type valueError struct {
+	value string
+	err   error
+}
+
+func (e *valueError) Error() string {
+	return e.err.Error()
+}
+
+func (e *valueError) Unwrap() error {
+	return e.err
+}
+
+// newValueError formats a validation error about the given configuration value
+func newValueError(value, format string, args ...interface{}) error {
+	return &valueError{value: value, err: fmt.Errorf(format, args...)}
+}
+
+// flattenErrors expands the errors combined with errors.Join into a flat list
+func flattenErrors(err error) []error {
+	if err == nil {
+		return nil
+	}
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return []error{err}
+	}
+	var flat []error
+	for _, e := range joined.Unwrap() {
+		flat = append(flat, flattenErrors(e)...)
+	}
+	return flat
+}
+
+// configProblem is one problem reported by the validate command
+type configProblem struct {
+	Message string `json:"message"`
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"`
+}
+
+// locateValue returns the file and line number where value appears, preferring later files
+// since they override earlier ones. It returns an empty file name if the value is not found.
+func locateValue(files []string, value string) (string, int) {
+	if value == "" {
+		return "", 0
+	}
+	for i := len(files) - 1; i >= 0; i-- {
+		data, err := os.ReadFile(files[i])
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for line := 1; scanner.Scan(); line++ {
+			if bytes.Contains(scanner.Bytes(), []byte(value)) {
+				return files[i], line
+			}
+		}
+	}
+	return "", 0
+}
+
+// newConfigProblems converts validation errors into problems, locating the offending values
+func newConfigProblems(files []string, err error) []configProblem {
+	var problems []configProblem
+	for _, e := range flattenErrors(err) {
+		problem := configProblem{Message: e.Error()}
+		var valueErr *valueError
+		if errors.As(e, &valueErr) {
+			problem.File, problem.Line = locateValue(files, valueErr.value)
+		}
+		problems = append(problems, problem)
+	}
+	return problems
+}
+
+// validateSummary is the result printed by the validate command in JSON output mode
+type validateSummary struct {
+	Valid    bool            `json:"valid"`
+	Problems []configProblem `json:"problems"`
+}
+
+// newValidateFlags creates the flag set of the validate command
+func newValidateFlags() *flag.FlagSet {
+	return newFlagSet("validate", "", "Check the configuration without credentials or API calls, reporting every problem found.")
+}
+
+// validateCommand checks the configuration offline, without credentials or API calls
+func validateCommand(ctx context.Context, source *configSource, args []string) {
+	newValidateFlags().Parse(args)
+
+	// Sitemaps are not fetched, so the check needs no network access
+	source.offline = true
+	var err error
+	config, loadErr := loadConfig(ctx, source)
+	if loadErr != nil {
+		err = loadErr
+	} else {
+		config.PurgeConfig.Paths = normalizePaths(config.PurgeConfig.Paths, config.PurgeConfig.LowercaseHost)
+		warnPathModes(config.PurgeConfig.Paths, config.PurgeConfig.PurgeMode)
+		warnMainlandOnly(config)
+		warnRedundantAreas(config.PurgeConfig.Areas)
+		err = errors.Join(validateProfileSettings(config.TencentCloud), validatePurgeConfig(config), validateNotifyConfig(config), validateConflicts(config))
+		if len(config.PushConfig.Urls) > 0 {
+			err = errors.Join(err, validatePushConfig(config))
+		}
+	}
+
+	summary := validateSummary{Problems: newConfigProblems(source.files, err)}
+	summary.Valid = len(summary.Problems) == 0
+	if summary.Problems == nil {
+		summary.Problems = []configProblem{}
+	}
+	if summary.Valid {
+		out.Successf("Configuration is valid\n")
+		out.Result(summary)
+		return
+	}
+
+	if out.format == outputJSON {
+		out.JSON(summary)
+	}
+	out.Errorf("Configuration check found %d problem(s):\n", len(summary.Problems))
+	for _, problem := range summary.Problems {
+		if problem.File != "" {
+			out.Errorf("  %s:%d: %s\n", problem.File, problem.Line, problem.Message)
+		} else {
+			out.Errorf("  %s\n", problem.Message)
+		}
+	}
+	exit(exitConfig, fmt.Sprintf("configuration check found %d problem(s)", len(summary.Problems)))
+}
@@ -0,0 +1,21 @@
+package main
+
+import "fmt"
+
+// Build metadata, overridden at build time with
+// -ldflags "-X main.version=... -X main.commit=... -X main.date=..."
+var (
+	version = "dev"
+	commit  = "unknown"
+	date    = "unknown"
+)
+
+// versionString describes the running binary for --version and auditing
+func versionString() string {
+	return fmt.Sprintf("PurgeCOSPathCache %s (commit %s, built %s)", version, commit, date)
+}
+
+// userAgent identifies this tool and its version in the User-Agent of API requests
+func userAgent() string {
+	return fmt.Sprintf("PurgeCOSPathCache/%s (commit %s)", version, commit)
+}
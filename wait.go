@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	cdn "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/cdn/v20180606"
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+
+	"git.ghink.net/ghink/PurgeCOSPathCache/purge"
+)
+
+// Default bounds for the --wait loop, in seconds
+const (
+	defaultWaitTimeout  = 300
+	defaultPollInterval = 5
+)
+
+// Purge task statuses reported by DescribePurgeTasks
+const (
+	taskStatusDone    = "done"
+	taskStatusFail    = "fail"
+	taskStatusProcess = "process"
+)
+
+// describePurgeTaskLimit is the maximum page size accepted by DescribePurgeTasks
+const describePurgeTaskLimit = 1000
+
+// describePurgeTasks returns every task entry matching the request filters,
+// paging through the results with Offset and Limit
+func describePurgeTasks(ctx context.Context, acct *account, request *cdn.DescribePurgeTasksRequest) ([]*cdn.PurgeTask, error) {
+	var tasks []*cdn.PurgeTask
+	request.Limit = common.Int64Ptr(describePurgeTaskLimit)
+	for offset := int64(0); ; offset += describePurgeTaskLimit {
+		request.Offset = common.Int64Ptr(offset)
+
+		var response *cdn.DescribePurgeTasksResponse
+		err := acct.call(ctx, "DescribePurgeTasks", func() (err error) {
+			response, err = acct.client.DescribePurgeTasksWithContext(ctx, request)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// A response without a body lists no tasks yet, like a task not indexed yet
+		if response.Response == nil {
+			return tasks, nil
+		}
+		logs := response.Response.PurgeLogs
+		tasks = append(tasks, logs...)
+		if len(logs) < describePurgeTaskLimit ||
+			(response.Response.TotalCount != nil && int64(len(tasks)) >= *response.Response.TotalCount) {
+			return tasks, nil
+		}
+	}
+}
+
+// describePurgeTask returns the per-URL entries of a purge task
+func describePurgeTask(ctx context.Context, acct *account, taskID string) ([]*cdn.PurgeTask, error) {
+	request := cdn.NewDescribePurgeTasksRequest()
+	request.TaskId = common.StringPtr(taskID)
+	return describePurgeTasks(ctx, acct, request)
+}
+
+// pollPurgeTask queries a task once and reports whether every URL is done,
+// returning the URLs still pending
+func pollPurgeTask(ctx context.Context, acct *account, taskID string) (bool, []string, error) {
+	tasks, err := describePurgeTask(ctx, acct, taskID)
+	if err != nil {
+		return false, nil, err
+	}
+
+	// Sort the task entries into failed and still pending ones
+	var failed, pending []string
+	for _, task := range tasks {
+		if task == nil {
+			continue
+		}
+		switch stringValue(task.Status) {
+		case taskStatusDone:
+		case taskStatusFail:
+			failed = append(failed, stringValue(task.Url))
+		default:
+			pending = append(pending, stringValue(task.Url))
+		}
+	}
+
+	if len(failed) > 0 {
+		return false, nil, fmt.Errorf("task %s failed for: %s", taskID, strings.Join(failed, ", "))
+	}
+	// A freshly submitted task may not be listed yet, keep polling until it shows up
+	return len(pending) == 0 && len(tasks) > 0, pending, nil
+}
+
+// waitForPurgeTasks polls DescribePurgeTasks until every URL of every task is done,
+// any URL fails, the timeout elapses or ctx is done
+func waitForPurgeTasks(ctx context.Context, acct *account, taskIDs []string, timeout, interval time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	remaining := taskIDs
+	taskProgress := out.Progress("tasks %d/%d done")
+	defer taskProgress.Done()
+	for {
+		var stillRunning []string
+		var pending []string
+		for _, taskID := range remaining {
+			done, taskPending, err := pollPurgeTask(ctx, acct, taskID)
+			if err != nil {
+				return err
+			}
+			if done {
+				continue
+			}
+			stillRunning = append(stillRunning, taskID)
+			if len(taskPending) == 0 {
+				pending = append(pending, fmt.Sprintf("%s (not reported yet)", taskID))
+			}
+			for _, url := range taskPending {
+				pending = append(pending, fmt.Sprintf("%s (%s)", taskID, url))
+			}
+		}
+
+		taskProgress.Update(len(taskIDs)-len(stillRunning), len(taskIDs))
+		if len(stillRunning) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%w after %s, tasks still in %s: %s", errWaitTimeout, timeout, taskStatusProcess, strings.Join(pending, ", "))
+		}
+
+		remaining = stillRunning
+		if err := purge.SleepContext(ctx, interval); err != nil {
+			return err
+		}
+	}
+}
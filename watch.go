@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// defaultWatchDebounce is how long watch waits after the last change before purging
+const defaultWatchDebounce = 500 * time.Millisecond
+
+// watchFlags are the flags of the watch command
+type watchFlags struct {
+	*flag.FlagSet
+	debounce *time.Duration
+}
+
+// newWatchFlags creates the flag set of the watch command
+func newWatchFlags() *watchFlags {
+	fs := newFlagSet("watch", "", "Watch purge_config.changed_files.root and purge the URLs of the files that change under\n"+
+		"changed_files.base_url, until interrupted. Intended for local development.")
+	return &watchFlags{
+		FlagSet:  fs,
+		debounce: fs.Duration("debounce", defaultWatchDebounce, "Purge once no file changed for this long, so a burst of saves is purged together"),
+	}
+}
+
+// watchCommand purges the URLs of the files changed under purge_config.changed_files.root
+// as they change, until interrupted
+func watchCommand(ctx context.Context, source *configSource, args []string) {
+	flags := newWatchFlags()
+	flags.Parse(args)
+	debounce := *flags.debounce
+	if debounce <= 0 {
+		out.Exitf(exitConfig, "-debounce must be positive")
+	}
+
+	config := loadCommandConfig(ctx, source)
+	changed := config.PurgeConfig.ChangedFiles
+	if changed.Root == "" || !hasHTTPScheme(changed.BaseURL) {
+		out.Exitf(exitConfig, "watch requires purge_config.changed_files.root and a base_url starting with http:// or https://")
+	}
+	if err := validateProfiles(config.TencentCloud); err != nil {
+		out.Exitf(exitConfig, "Configuration validation failed: %v", err)
+	}
+	// Changed files are single URLs, never directories with a flush type
+	config.PurgeConfig.PurgeMode = purgeModeURL
+	config.PurgeConfig.FlushType, config.PurgeConfig.FlushTypes = "", nil
+
+	// The accounts are kept for the whole session, so their rate limits span every purge
+	var accounts []*account
+	for i := range config.TencentCloud {
+		acct, err := newAccount(&config.TencentCloud[i])
+		if err != nil {
+			out.Exitf(exitCodeFor(err), "Error creating CDN client for profile %s: %v", config.TencentCloud[i].Name, err)
+		}
+		accounts = append(accounts, acct)
+	}
+
+	notifier, err := fsnotify.NewWatcher()
+	if err != nil {
+		out.Exitf(exitFailure, "Error watching %s: %v", changed.Root, err)
+	}
+	defer notifier.Close()
+	if err := watchTree(notifier, changed.Root); err != nil {
+		out.Exitf(exitFailure, "Error watching %s: %v", changed.Root, err)
+	}
+	out.Printf("Watching %s, purging changes under %s (Ctrl-C to stop)\n", changed.Root, changed.BaseURL)
+
+	batcher := newWatchBatcher(time.Duration(config.PurgeConfig.DedupeWindow) * time.Second)
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case err := <-notifier.Errors:
+			logger.Error("file watch error", "error", err)
+		case event := <-notifier.Events:
+			file, ok := watchedFile(notifier, event)
+			if !ok {
+				continue
+			}
+			rel, err := filepath.Rel(changed.Root, file)
+			if err != nil {
+				continue
+			}
+			logger.Debug("file changed", "file", rel, "op", event.Op.String())
+			batcher.add(fileURL(changed.BaseURL, rel))
+			timer.Reset(debounce)
+		case <-timer.C:
+			urls, retry := batcher.ready(time.Now())
+			// A failed purge does not start the dedupe window, the next save retries it
+			if len(urls) > 0 {
+				if err := purgeChangedURLs(ctx, accounts, config, urls); err != nil {
+					out.Errorf("Purge failed: %v\n", err)
+				} else {
+					batcher.purged(urls, time.Now())
+				}
+			}
+			// URLs held back by the dedupe window are purged once it has passed
+			if retry > 0 {
+				timer.Reset(retry)
+			}
+		}
+	}
+}
+
+// watchTree watches root and every directory below it, fsnotify watching a single level
+func watchTree(notifier *fsnotify.Watcher, root string) error {
+	return filepath.WalkDir(root, func(dir string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.IsDir() {
+			return err
+		}
+		return notifier.Add(dir)
+	})
+}
+
+// watchedFile returns the file an event reports a change of, watching directories as they
+// are created. Attribute changes and editor scratch files, named .* or *~, are skipped.
+func watchedFile(notifier *fsnotify.Watcher, event fsnotify.Event) (string, bool) {
+	if !event.Has(fsnotify.Write | fsnotify.Create | fsnotify.Remove | fsnotify.Rename) {
+		return "", false
+	}
+	name := filepath.Base(event.Name)
+	if strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~") {
+		return "", false
+	}
+	if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+		if event.Has(fsnotify.Create) {
+			if err := watchTree(notifier, event.Name); err != nil {
+				logger.Error("failed to watch new directory", "dir", event.Name, "error", err)
+			}
+		}
+		return "", false
+	}
+	return event.Name, true
+}
+
+// watchBatcher collects the URLs of changed files until they are purged, holding back the
+// URLs purged within the dedupe window so rapid saves do not flood the API
+type watchBatcher struct {
+	window     time.Duration
+	pending    map[string]bool
+	lastPurged map[string]time.Time
+}
+
+// newWatchBatcher returns a batcher with nothing pending
+func newWatchBatcher(window time.Duration) *watchBatcher {
+	return &watchBatcher{window: window, pending: map[string]bool{}, lastPurged: map[string]time.Time{}}
+}
+
+// add records a changed URL
+func (b *watchBatcher) add(url string) {
+	b.pending[url] = true
+}
+
+// ready removes and returns the pending URLs that may be purged at now, sorted, along with
+// how long until the next held back URL leaves the dedupe window, 0 if none is held back
+func (b *watchBatcher) ready(now time.Time) ([]string, time.Duration) {
+	var urls []string
+	var retry time.Duration
+	for url := range b.pending {
+		wait := b.lastPurged[url].Add(b.window).Sub(now)
+		if wait <= 0 {
+			urls = append(urls, url)
+			delete(b.pending, url)
+		} else if retry == 0 || wait < retry {
+			retry = wait
+		}
+	}
+	sort.Strings(urls)
+	return urls, retry
+}
+
+// purged records when urls were purged, starting their dedupe window
+func (b *watchBatcher) purged(urls []string, now time.Time) {
+	for _, url := range urls {
+		b.lastPurged[url] = now
+	}
+}
+
+// purgeChangedURLs purges urls with every account, validated like the paths of a purge run
+func purgeChangedURLs(ctx context.Context, accounts []*account, config *Config, urls []string) error {
+	batch := *config
+	batch.PurgeConfig.Paths = urls
+	normalizeConfigPaths(&batch, false)
+	if err := validateConfig(&batch); err != nil {
+		return err
+	}
+
+	out.Printf("Purging %s at %s\n", countOf(len(urls), "changed file"), time.Now().Format(time.TimeOnly))
+	var problems []error
+	for _, acct := range accounts {
+		if len(accounts) > 1 {
+			out.Printf("Profile %s:\n", acct.profile.Name)
+		}
+		if _, err := runAccount(ctx, acct, &batch, runOptions{}); err != nil {
+			problems = append(problems, fmt.Errorf("profile %s: %w", acct.profile.Name, err))
+		}
+	}
+	return errors.Join(problems...)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// unknownAnchorPattern matches the yaml.v3 error for an alias of an undefined anchor, which
+// carries no position of its own
+var unknownAnchorPattern = regexp.MustCompile(`unknown anchor '([^']*)' referenced`)
+
+// unmarshalYAML decodes YAML data into out, resolving anchors, aliases and merge keys.
+// Errors name the line, and the column where it is known, of the offending content.
+func unmarshalYAML(data []byte, out interface{}) error {
+	return unmarshalExpandedYAML(data, out, nil)
+}
+
+// unmarshalExpandedYAML is unmarshalYAML expanding the values of the document with env,
+// unless it is nil, before decoding them
+func unmarshalExpandedYAML(data []byte, out interface{}, env *envExpander) error {
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return describeYAMLError(data, err)
+	}
+	// An empty document decodes to nothing, leaving out unchanged
+	if document.Kind == 0 {
+		return nil
+	}
+	if env != nil {
+		env.expandNode(&document)
+	}
+	if err := document.Decode(out); err != nil {
+		return describeYAMLError(data, err)
+	}
+	return nil
+}
+
+// describeYAMLError rewrites a yaml.v3 error as one line per problem, each starting with
+// its position, and without the "yaml:" prefix
+func describeYAMLError(data []byte, err error) error {
+	var typeError *yaml.TypeError
+	if errors.As(err, &typeError) {
+		return errors.New(strings.Join(typeError.Errors, "; "))
+	}
+	message := strings.TrimPrefix(err.Error(), "yaml: ")
+	if match := unknownAnchorPattern.FindStringSubmatch(message); match != nil {
+		if line, column := locateAlias(data, match[1]); line > 0 {
+			return fmt.Errorf("line %d, column %d: %s", line, column, message)
+		}
+	}
+	return errors.New(message)
+}
+
+// locateAlias returns the line and column of the first alias of anchor in data, or zeros if
+// there is none
+func locateAlias(data []byte, anchor string) (int, int) {
+	alias := []byte("*" + anchor)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		if column := bytes.Index(scanner.Bytes(), alias); column >= 0 {
+			return line, column + 1
+		}
+	}
+	return 0, 0
+}
+
+// nodeErrorf returns an error positioned at node, as reported by custom YAML unmarshalers
+func nodeErrorf(node *yaml.Node, format string, args ...interface{}) error {
+	return fmt.Errorf("line %d, column %d: %s", node.Line, node.Column, fmt.Sprintf(format, args...))
+}